@@ -1,25 +1,31 @@
 package cbor
 
 import "fmt"
-import "bytes"
+import "errors"
+import "math"
 import "strings"
 import "strconv"
+import "reflect"
+import "unicode/utf8"
+
+// ErrNotInteger is returned by Int64 for an item that is not an integer.
+var ErrNotInteger = errors.New("cbor: not an integer")
+
+// ErrIntegerOverflow is returned by Int64 for an integer outside the range of
+// int64.
+var ErrIntegerOverflow = errors.New("cbor: integer overflows int64")
 
 type CborValue struct {
 	ctype int
-	blob bytes.Buffer
-	integer uint64
-	real float64
 	ctrl int
-
-	// tag
-	tag_item uint64
-	tag_content *CborValue
-
-	key, value *CborValue	// pair
-	first, last *CborValue	// container
-	next, prev *CborValue	// entry
+	num uint64		// integer argument, tag number, float64 bits, or the
+			// entries removed from the front of a container
+	blob []byte
+	items []*CborValue	// container entries, pair key and value, tag content
 	parent *CborValue
+	pos int			// index in parent.items plus parent.num
+	index map[string]*CborValue	// key index of a large map
+	converted interface{}	// value of a tag a TagSet converted on decode
 }
 
 const (
@@ -32,6 +38,7 @@ const (
 	CBOR_TYPE_TAG        int = 6
 	CBOR_TYPE_SIMPLE     int = 7
 	CBOR__TYPE_PAIR      int = 33
+	CBOR__TYPE_RAW       int = 34
 )
 
 const (
@@ -48,8 +55,8 @@ func (self *CborValue) Compare(T interface{}) bool {
 	case string:
 		v := T.(string)
 		if self.ctype == CBOR_TYPE_BYTESTRING || self.ctype == CBOR_TYPE_STRING {
-			if len(v) == self.blob.Len() {
-				if bytes.Compare(self.blob.Bytes(), []byte(v)) == 0 {
+			if len(v) == len(self.blob) {
+				if string(self.blob) == v {
 					return true
 				} else {
 					return false
@@ -64,14 +71,72 @@ func (self *CborValue) Compare(T interface{}) bool {
 		if self.ctype == CBOR_TYPE_SIMPLE && self.ctrl == CBOR_SIMPLE_REAL {
 			return self.Float() == T.(float64)
 		}
+	case int, int8, int16, int32, int64:
+		i := reflect.ValueOf(T).Int()
+		if self.ctype == CBOR_TYPE_UINT {
+			return i >= 0 && uint64(i) == self.num
+		} else if self.ctype == CBOR_TYPE_NEGINT {
+			return i < 0 && uint64(-1 - i) == self.num
+		}
+	case uint, uint8, uint16, uint32, uint64:
+		if self.ctype == CBOR_TYPE_UINT {
+			return reflect.ValueOf(T).Uint() == self.num
+		}
 	case nil:
 		if self.IsNull() {
 			return true
 		}
+	case Tag:
+		tag := T.(Tag)
+		if self.IsTag() && self.num == tag.Number && self.TagContent() != nil {
+			return self.TagContent().Compare(tag.Content)
+		}
 	}
 	return false
 }
 
+// pointer_integer returns the integer a JSON Pointer reference token is the
+// canonical decimal form of, or nil.
+func pointer_integer(ele string) interface{} {
+	if i, err := strconv.ParseInt(ele, 10, 64); err == nil && strconv.FormatInt(i, 10) == ele {
+		return i
+	} else if u, err := strconv.ParseUint(ele, 10, 64); err == nil && strconv.FormatUint(u, 10) == ele {
+		return u
+	}
+	return nil
+}
+
+// pointer_pair finds the pair of a map whose key matches a JSON Pointer
+// reference token, either as a string or as the decimal form of an integer.
+func (container *CborValue) pointer_pair(ele string) *CborValue {
+	integer := pointer_integer(ele)
+	if index := container.map_index(); index != nil {
+		var found *CborValue = nil
+		var scan bool = false
+		keys := []*CborValue{NewString(ele), NewBytestring([]byte(ele))}
+		if integer != nil {
+			keys = append(keys, New(integer))
+		}
+		for _, key := range keys {
+			k, _ := map_key(key)
+			if pair, ok := index[k]; ok && pair == nil {
+				scan = true
+			} else if pair != nil && (found == nil || pair.pos < found.pos) {
+				found = pair
+			}
+		}
+		if !scan {
+			return found
+		}
+	}
+	for elm := container.ContainerFirst(); elm != nil; elm = container.ContainerNext(elm) {
+		if elm.PairKey().Compare(ele) || (integer != nil && elm.PairKey().Compare(integer)) {
+			return elm
+		}
+	}
+	return nil
+}
+
 func (val *CborValue) IsString() bool {
 	return val != nil && (val.ctype == CBOR_TYPE_STRING || val.ctype == CBOR_TYPE_BYTESTRING)
 }
@@ -93,12 +158,18 @@ func (val *CborValue) IsBoolean() bool {
 func (val *CborValue) IsNull() bool {
 	return val != nil && val.ctype == CBOR_TYPE_SIMPLE && val.ctrl == CBOR_SIMPLE_NULL
 }
+func (val *CborValue) IsTag() bool {
+	return val != nil && val.ctype == CBOR_TYPE_TAG
+}
+func (val *CborValue) IsRaw() bool {
+	return val != nil && val.ctype == CBOR__TYPE_RAW
+}
 func (val *CborValue) IsContainer() bool {
 	return val != nil && (val.ctype == CBOR_TYPE_MAP || val.ctype == CBOR_TYPE_ARRAY)
 }
 func (val *CborValue) ContainerEmpty() bool {
 	if val.IsContainer() {
-		return val.first == nil && val.last == nil
+		return len(val.items) == 0
 	}
 	return false
 }
@@ -106,7 +177,7 @@ func (val *CborValue) ContainerEmpty() bool {
 func New(value interface{}) *CborValue {
 	switch v := value.(type) {
 	case uint:
-		return NewInteger(int64(v))
+		return NewUint64(uint64(v))
 	case uint8:
 		return NewInteger(int64(v))
 	case uint16:
@@ -114,7 +185,7 @@ func New(value interface{}) *CborValue {
 	case uint32:
 		return NewInteger(int64(v))
 	case uint64:
-		return NewInteger(int64(v))
+		return NewUint64(v)
 	case int:
 		return NewInteger(int64(v))
 	case int8:
@@ -158,7 +229,11 @@ func New(value interface{}) *CborValue {
 	case *CborValue:
 		return value.(*CborValue).Duplicate()
 	}
-	return nil
+	val, err := marshal_value(reflect.ValueOf(value), &EncodeOptions{}, 0)
+	if err != nil {
+		return nil
+	}
+	return val
 }
 
 func NewTag() *CborValue {
@@ -167,6 +242,19 @@ func NewTag() *CborValue {
 	return val
 }
 
+// NewTagged returns a tag wrapping content, a copy of it if it already
+// belongs to another item.
+func NewTagged(number uint64, content *CborValue) *CborValue {
+	if content == nil || content.parent != nil {
+		content = content.Duplicate()
+	}
+	val := NewTag()
+	val.num = number
+	val.items = make([]*CborValue, 0, 1)
+	val.adopt(content)
+	return val
+}
+
 func NewUndef() *CborValue {
 	val := new(CborValue)
 	val.ctype = CBOR_TYPE_SIMPLE
@@ -174,6 +262,10 @@ func NewUndef() *CborValue {
 	return val
 }
 
+// NewExt returns the reserved simple value 24.
+//
+// Deprecated: simple values 24 to 31 are not well-formed, the node encodes
+// as undefined.
 func NewExt() *CborValue {
 	val := new(CborValue)
 	val.ctype = CBOR_TYPE_SIMPLE
@@ -207,27 +299,42 @@ func NewInteger(i int64) *CborValue {
 	val := new(CborValue)
 	if i < 0 {
 		val.ctype = CBOR_TYPE_NEGINT
-		val.integer = uint64(-i -1)
+		val.num = uint64(-i -1)
 	} else {
 		val.ctype = CBOR_TYPE_UINT
-		val.integer = uint64(i)
+		val.num = uint64(i)
 	}
 	return val
 }
 
+// NewUint64 returns the unsigned integer u, the full range of major type 0.
+func NewUint64(u uint64) *CborValue {
+	val := new(CborValue)
+	val.ctype = CBOR_TYPE_UINT
+	val.num = u
+	return val
+}
+
+// NewNegative returns the negative integer -1 - n, the full range of major
+// type 1.
+func NewNegative(n uint64) *CborValue {
+	val := new(CborValue)
+	val.ctype = CBOR_TYPE_NEGINT
+	val.num = n
+	return val
+}
+
 func NewString(s string) *CborValue {
 	val := new(CborValue)
 	val.ctype = CBOR_TYPE_STRING
-	val.blob = bytes.Buffer{}
-	val.blob.WriteString(s)
+	val.blob = []byte(s)
 	return val
 }
 
 func NewBytestring(b []byte) *CborValue {
 	val := new(CborValue)
 	val.ctype = CBOR_TYPE_BYTESTRING
-	val.blob = bytes.Buffer{}
-	val.blob.Write(b)
+	val.blob = append([]byte{}, b...)
 	return val
 }
 
@@ -242,45 +349,92 @@ func NewFloat(real float64) *CborValue {
 	val := new(CborValue)
 	val.ctype = CBOR_TYPE_SIMPLE
 	val.ctrl = CBOR_SIMPLE_REAL
-	val.real = real
+	val.num = math.Float64bits(real)
+	return val
+}
+// NewRaw wraps the encoding of a single data item, which the encoder writes
+// out verbatim, or re-encodes in the deterministic modes. It returns nil if b
+// is not exactly one well-formed item.
+func NewRaw(b []byte) *CborValue {
+	if Valid(b) != nil {
+		return nil
+	}
+	val := new(CborValue)
+	val.ctype = CBOR__TYPE_RAW
+	val.blob = append([]byte{}, b...)
 	return val
 }
+
 func NewPair(key *CborValue, val *CborValue) *CborValue {
 	pair := new(CborValue)
 	pair.ctype = CBOR__TYPE_PAIR
-	pair.key = key
-	pair.value = val
-	key.parent = pair
-	val.parent = pair
+	pair.items = make([]*CborValue, 0, 2)
+	pair.adopt(key)
+	pair.adopt(val)
 	return pair
 }
 
+// Integer returns an integer or float as int64. Integers outside the range of
+// int64 wrap, use Int64, Uint64 or BigInt for those.
 func (val *CborValue) Integer() int64 {
 	if val == nil {
 		return 0
 	}
 
 	if val.ctype == CBOR_TYPE_UINT {
-		return int64(val.integer)
+		return int64(val.num)
 	} else if val.ctype == CBOR_TYPE_NEGINT {
-		return -1 - int64(val.integer)
+		return -1 - int64(val.num)
 	} else if val.ctype == CBOR_TYPE_SIMPLE && val.ctrl == CBOR_SIMPLE_REAL {
-		return int64(val.real)
+		return int64(math.Float64frombits(val.num))
 	}
 	return 0
 }
 
+// Uint64 returns the value of an unsigned integer, or false for any other
+// item.
+func (val *CborValue) Uint64() (uint64, bool) {
+	if val == nil || val.ctype != CBOR_TYPE_UINT {
+		return 0, false
+	}
+	return val.num, true
+}
+
+// Int64 returns the value of an integer, ErrIntegerOverflow when it is
+// outside the range of int64, or ErrNotInteger for any other item.
+func (val *CborValue) Int64() (int64, error) {
+	if !val.IsInteger() {
+		return 0, ErrNotInteger
+	}
+	if val.num > math.MaxInt64 {
+		return 0, ErrIntegerOverflow
+	}
+	if val.ctype == CBOR_TYPE_NEGINT {
+		return -1 - int64(val.num), nil
+	}
+	return int64(val.num), nil
+}
+
 func (val *CborValue) Float() float64 {
 	if val == nil {
 		return 0.0
 	}
 
 	if val.ctype == CBOR_TYPE_UINT {
-		return float64(val.integer)
+		return float64(val.num)
 	} else if val.ctype == CBOR_TYPE_NEGINT {
-		return float64(-1 - int64(val.integer))
+		return -1 - float64(val.num)
 	} else if val.ctype == CBOR_TYPE_SIMPLE && val.ctrl == CBOR_SIMPLE_REAL {
-		return val.real
+		return math.Float64frombits(val.num)
+	} else if val.ctype == CBOR_TYPE_TAG && is_big_tag(val.num) {
+		// the nearest float to a big number
+		if f := val.BigFloat(); f != nil {
+			real, _ := f.Float64()
+			return real
+		} else if r := val.BigRat(); r != nil {
+			real, _ := r.Float64()
+			return real
+		}
 	}
 	return .0
 }
@@ -291,7 +445,7 @@ func (val *CborValue) String() string {
 	}
 
 	if val.ctype == CBOR_TYPE_STRING || val.ctype == CBOR_TYPE_BYTESTRING {
-		return val.blob.String()
+		return string(val.blob)
 	}
 	return ""
 }
@@ -302,18 +456,26 @@ func (val *CborValue) StringBytes() []byte {
 	}
 
 	if val.ctype == CBOR_TYPE_STRING || val.ctype == CBOR_TYPE_BYTESTRING {
-		return val.blob.Bytes()
+		return val.blob
 	}
 	return []byte("")
 }
 
+// Raw returns the encoding wrapped by a node made with NewRaw.
+func (val *CborValue) Raw() []byte {
+	if val.IsRaw() {
+		return val.blob
+	}
+	return nil
+}
+
 func (val *CborValue) StringSize() int {
 	if val == nil {
 		return 0
 	}
 
 	if val.ctype == CBOR_TYPE_STRING || val.ctype == CBOR_TYPE_BYTESTRING {
-		return val.blob.Len()
+		return len(val.blob)
 	}
 	return 0
 }
@@ -333,16 +495,65 @@ func (val *CborValue) Boolean() bool {
 	return false
 }
 
+func (val *CborValue) TagNumber() uint64 {
+	if val.IsTag() {
+		return val.num
+	}
+	return 0
+}
+
+// TagContent returns the item a tag wraps.
+func (val *CborValue) TagContent() *CborValue {
+	if val.IsTag() && len(val.items) > 0 {
+		return val.items[0]
+	}
+	return nil
+}
+
+// TagValue returns the Go value the content of a tag was converted to by the
+// TagSet it was decoded with, or nil for a tag that is not registered there.
+func (val *CborValue) TagValue() interface{} {
+	if val.IsTag() {
+		return val.converted
+	}
+	return nil
+}
+
+// SetTagContent replaces the item a tag wraps, like SetValue does for the
+// value of a pair.
+func (tag *CborValue) SetTagContent(val *CborValue) {
+	if val == nil || val.parent != nil || !tag.IsTag() {
+		return
+	}
+	tag.converted = nil
+	if len(tag.items) == 0 {
+		tag.adopt(val)
+		return
+	}
+	tag.items[0].parent = nil
+	val.parent = tag
+	val.pos = 0
+	tag.items[0] = val
+}
+
+// untag returns the content of val with any tags around it taken off.
+func (val *CborValue) untag() *CborValue {
+	for val.IsTag() {
+		val = val.TagContent()
+	}
+	return val
+}
+
 func (pair *CborValue) PairKey() *CborValue {
 	if pair != nil && pair.ctype == CBOR__TYPE_PAIR {
-		return pair.key
+		return pair.items[0]
 	}
 	return nil
 }
 
 func (pair *CborValue) PairValue() *CborValue {
 	if pair != nil && pair.ctype == CBOR__TYPE_PAIR {
-		return pair.value
+		return pair.items[1]
 	}
 	return nil
 }
@@ -353,37 +564,80 @@ func (pair *CborValue) SetValue(val *CborValue) {
 	}
 
 	if pair != nil && pair.ctype == CBOR__TYPE_PAIR {
-		v := pair.value
-		v.parent = nil
+		if v := pair.items[1]; v != nil {
+			v.parent = nil
+		}
 		val.parent = pair
-		pair.value = val
+		val.pos = 1
+		pair.items[1] = val
 	}
 }
 
 func (s *CborValue) BlobAppendByte(b byte) {
 	if s.IsString() {
-		s.blob.WriteByte(b)
+		s.blob = append(s.blob, b)
+		s.key_changed()
 	}
 }
 
 func (s *CborValue) BlobAppendRune(r rune) {
 	if s.IsString() {
-		s.blob.WriteRune(r)
+		s.blob = utf8.AppendRune(s.blob, r)
+		s.key_changed()
 	}
 }
 
 func (s *CborValue) BlobAppend(str string) {
 	if s.IsString() {
-		s.blob.WriteString(str)
+		s.blob = append(s.blob, str...)
+		s.key_changed()
 	}
 }
 
 func (s *CborValue) BlobAppendFormat(format string, va ...interface{}) {
 	if s.IsString() {
-		s.blob.WriteString(fmt.Sprintf(format, va...))
+		s.blob = append(s.blob, fmt.Sprintf(format, va...)...)
+		s.key_changed()
 	}
 }
 
+// entry_index returns the index of the entry val in container.items. Entries
+// keep their pos when the front entry is removed, container.num counts those
+// removals instead.
+func (container *CborValue) entry_index(val *CborValue) int {
+	return val.pos - int(container.num)
+}
+
+// adopt appends val to the entries of container.
+func (container *CborValue) adopt(val *CborValue) {
+	val.parent = container
+	val.pos = int(container.num) + len(container.items)
+	container.items = append(container.items, val)
+	if container.index != nil {
+		container.index_add(val)
+	} else {
+		container.index_build()
+	}
+}
+
+// insert puts val at index pos of the entries of container, shifting the
+// entries after it.
+func (container *CborValue) insert(pos int, val *CborValue) {
+	container.items = append(container.items, nil)
+	copy(container.items[pos+1:], container.items[pos:])
+	container.items[pos] = val
+	val.parent = container
+	for i := pos; i < len(container.items); i++ {
+		container.items[i].pos = int(container.num) + i
+	}
+	if container.index != nil {
+		container.index_add(val)
+	} else {
+		container.index_build()
+	}
+}
+
+// ContainerInsertTail appends val to an array or map in constant time.
 func (container *CborValue) ContainerInsertTail(val *CborValue) {
 	if val == nil || !container.IsContainer() {
 		return
@@ -391,18 +645,11 @@ func (container *CborValue) ContainerInsertTail(val *CborValue) {
 	if val.parent != nil {
 		val = val.Duplicate()
 	}
-
-	val.prev = container.last
-	if container.last != nil {
-		container.last.next = val;
-	}
-	container.last = val
-	if container.first == nil {
-		container.first = val
-	}
-	val.parent = container
+	container.adopt(val)
 }
 
+// ContainerInsertHead puts val first in an array or map. The entries after
+// it shift, so the cost grows with the size of the container.
 func (container *CborValue) ContainerInsertHead(val *CborValue) {
 	if val == nil || !container.IsContainer() {
 		return
@@ -410,53 +657,65 @@ func (container *CborValue) ContainerInsertHead(val *CborValue) {
 	if val.parent != nil {
 		val = val.Duplicate()
 	}
-	val.next = container.first
-	if container.first != nil {
-		container.first.prev = val
-	}
-	container.first = val
-	if container.last == nil {
-		container.last = val
-	}
-	val.parent = container
+	container.insert(0, val)
 }
 
 func (container *CborValue) ContainerSize() int {
-	var count = 0
 	if !container.IsContainer() {
-		return count
+		return 0
 	}
-	for val := container.ContainerFirst(); val != nil; val = container.ContainerNext(val) {
-		count += 1
+	return len(container.items)
+}
+
+// ContainerAt returns the entry at index i of an array or map, or nil when i
+// is out of range.
+func (container *CborValue) ContainerAt(i int) *CborValue {
+	if container.IsContainer() && i >= 0 && i < len(container.items) {
+		return container.items[i]
 	}
-	return count
+	return nil
 }
 
-func (container *CborValue) ContainerRemove(val *CborValue) {
+// ContainerIndexOf returns the index of val in container, or -1 when val is
+// not one of its entries.
+func (container *CborValue) ContainerIndexOf(val *CborValue) int {
 	if val != nil && container.IsContainer() && val.parent == container {
-		prev := val.prev
-		next := val.next
+		return container.entry_index(val)
+	}
+	return -1
+}
 
-		if prev != nil {
-			prev.next = next
-		}
-		if next != nil {
-			next.prev = prev
+// ContainerRemove takes val out of an array or map. Removing the first or the
+// last entry takes constant time, the entries after any other one shift.
+func (container *CborValue) ContainerRemove(val *CborValue) {
+	if val != nil && container.IsContainer() && val.parent == container {
+		if container.index != nil {
+			container.index_remove(val)
 		}
-		if container.first == val {
-			container.first = next
+		items := container.items
+		pos := container.entry_index(val)
+		if pos == 0 {
+			items[0] = nil
+			container.items = items[1:]
+			container.num++
+		} else {
+			copy(items[pos:], items[pos+1:])
+			items[len(items) - 1] = nil
+			container.items = items[:len(items) - 1]
+			for i := pos; i < len(container.items); i++ {
+				container.items[i].pos = int(container.num) + i
+			}
 		}
-		if container.last == val {
-			container.last = prev
+		if len(container.items) == 0 {
+			container.num = 0
 		}
-		val.prev = nil
-		val.next = nil
 		val.parent = nil
+		val.pos = 0
 	}
 }
 
 func (container *CborValue) PointerGet(path string) *CborValue {
-	if !container.IsContainer() {
+	if !container.untag().IsContainer() {
 		return nil
 	}
 
@@ -469,13 +728,9 @@ func (container *CborValue) PointerGet(path string) *CborValue {
 			current = container
 			continue
 		} else {
+			current = current.untag()
 			if current.IsMap() {
-				var elm *CborValue = nil
-				for elm = current.ContainerFirst(); elm != nil; elm = current.ContainerNext(elm) {
-					if elm.PairKey().Compare(ele) {
-						break
-					}
-				}
+				elm := current.pointer_pair(ele)
 				if elm != nil {
 					current = elm.PairValue()
 					continue
@@ -487,10 +742,7 @@ func (container *CborValue) PointerGet(path string) *CborValue {
 				} else {
 					idx, err := strconv.ParseInt(ele, 10, 32)
 					if err == nil && idx >= 0 {
-						var elm *CborValue = nil
-						for elm = current.ContainerFirst(); elm != nil && idx > 0; idx-- {
-							elm = current.ContainerNext(elm)
-						}
+						elm := current.ContainerAt(int(idx))
 						if elm != nil {
 							current = elm
 							continue
@@ -515,7 +767,7 @@ func (container *CborValue) PointerRemove(path string) *CborValue {
 				parent = pair.parent
 				if parent != nil {
 					parent.ContainerRemove(pair)
-					pair.value = nil
+					pair.items[1] = nil
 					return remval
 				}
 			} else if parent.ctype == CBOR_TYPE_ARRAY {
@@ -532,7 +784,7 @@ func (container *CborValue) PointerMove(path string, dest string) *CborValue {
 	var root *CborValue = nil
 	var value *CborValue = nil
 	last := false
-	if !container.IsContainer() {
+	if !container.untag().IsContainer() {
 		return nil
 	}
 
@@ -547,13 +799,9 @@ func (container *CborValue) PointerMove(path string, dest string) *CborValue {
 			current = container
 			continue
 		} else {
+			current = current.untag()
 			if current.IsMap() {
-				var elm *CborValue = nil
-				for elm = current.ContainerFirst(); elm != nil; elm = current.ContainerNext(elm) {
-					if elm.PairKey().Compare(ele) {
-						break
-					}
-				}
+				elm := current.pointer_pair(ele)
 				if elm != nil {
 					if last {
 						root = current
@@ -575,10 +823,7 @@ func (container *CborValue) PointerMove(path string, dest string) *CborValue {
 				} else {
 					idx, err := strconv.ParseInt(ele, 10, 32)
 					if err == nil && idx >= 0 {
-						var elm *CborValue = nil
-						for elm = current.ContainerFirst(); elm != nil && idx > 0; idx-- {
-							elm = current.ContainerNext(elm)
-						}
+						elm := current.ContainerAt(int(idx))
 						if elm != nil {
 							if last {
 								root = current
@@ -618,20 +863,16 @@ func (container *CborValue) PointerMove(path string, dest string) *CborValue {
 			current = container
 			continue
 		} else {
+			current = current.untag()
 			if current.IsMap() {
-				var elm *CborValue = nil
-				for elm = current.ContainerFirst(); elm != nil; elm = current.ContainerNext(elm) {
-					if elm.PairKey().Compare(ele) {
-						break
-					}
-				}
+				elm := current.pointer_pair(ele)
 				if elm != nil {
 					if last {
 						root.ContainerRemove(value)
 						if root.IsMap() {
 							tmp := value.PairValue()
 							tmp.parent = nil
-							value.value = nil
+							value.items[1] = nil
 							value = tmp
 						}
 						elm.SetValue(value)
@@ -645,7 +886,7 @@ func (container *CborValue) PointerMove(path string, dest string) *CborValue {
 						if root.IsMap() {
 							tmp := value.PairValue()
 							tmp.parent = nil
-							value.value = nil
+							value.items[1] = nil
 							value = tmp
 						}
 						pair := NewPair(New(ele), value)
@@ -659,7 +900,7 @@ func (container *CborValue) PointerMove(path string, dest string) *CborValue {
 						if root.IsMap() {
 							tmp := value.PairValue()
 							tmp.parent = nil
-							value.value = nil
+							value.items[1] = nil
 							value = tmp
 						}
 						current.ContainerInsertTail(value)
@@ -670,17 +911,14 @@ func (container *CborValue) PointerMove(path string, dest string) *CborValue {
 				} else {
 					idx, err := strconv.ParseInt(ele, 10, 32)
 					if err == nil && idx >= 0 {
-						var elm *CborValue = nil
-						for elm = current.ContainerFirst(); elm != nil && idx > 0; idx-- {
-							elm = current.ContainerNext(elm)
-						}
+						elm := current.ContainerAt(int(idx))
 						if elm != nil {
 							if last {
 								root.ContainerRemove(value)
 								if root.IsMap() {
 									tmp := value.PairValue()
 									tmp.parent = nil
-									value.value = nil
+									value.items[1] = nil
 									value = tmp
 								}
 								current.ContainerInsertBefore(elm, value)
@@ -702,7 +940,7 @@ func (container *CborValue) PointerMove(path string, dest string) *CborValue {
 func (container *CborValue) PointerAdd(path string, val *CborValue) *CborValue {
 	var current *CborValue = nil
 	last := false
-	if !container.IsContainer() || val == nil {
+	if !container.untag().IsContainer() || val == nil {
 		return nil
 	}
 
@@ -717,13 +955,9 @@ func (container *CborValue) PointerAdd(path string, val *CborValue) *CborValue {
 			current = container
 			continue
 		} else {
+			current = current.untag()
 			if current.IsMap() {
-				var elm *CborValue = nil
-				for elm = current.ContainerFirst(); elm != nil; elm = current.ContainerNext(elm) {
-					if elm.PairKey().Compare(ele) {
-						break
-					}
-				}
+				elm := current.pointer_pair(ele)
 				if elm != nil {
 					if last {
 						elm.SetValue(val)
@@ -748,10 +982,7 @@ func (container *CborValue) PointerAdd(path string, val *CborValue) *CborValue {
 				} else {
 					idx, err := strconv.ParseInt(ele, 10, 32)
 					if err == nil && idx >= 0 {
-						var elm *CborValue = nil
-						for elm = current.ContainerFirst(); elm != nil && idx > 0; idx-- {
-							elm = current.ContainerNext(elm)
-						}
+						elm := current.ContainerAt(int(idx))
 						if elm != nil {
 							if last {
 								current.ContainerInsertBefore(elm, val)
@@ -783,8 +1014,10 @@ func (val *CborValue) Duplicate() *CborValue { // deep copy
 		return New(nil)
 	}
 
-	if val.ctype == CBOR_TYPE_UINT || val.ctype == CBOR_TYPE_NEGINT {
-		return NewInteger(val.Integer())
+	if val.ctype == CBOR_TYPE_UINT {
+		return NewUint64(val.num)
+	} else if val.ctype == CBOR_TYPE_NEGINT {
+		return NewNegative(val.num)
 	} else if val.ctype == CBOR_TYPE_STRING {
 		return NewString(val.String())
 	} else if val.ctype == CBOR_TYPE_SIMPLE {
@@ -797,74 +1030,70 @@ func (val *CborValue) Duplicate() *CborValue { // deep copy
 		} else if val.ctrl == CBOR_SIMPLE_REAL {
 			return NewFloat(val.Float())
 		}
+		dup := NewUndef()
+		dup.ctrl = val.ctrl
+		return dup
+	} else if val.ctype == CBOR_TYPE_TAG {
+		dup := NewTagged(val.num, val.TagContent().Duplicate())
+		dup.converted = val.converted
+		return dup
 	} else if val.ctype == CBOR__TYPE_PAIR {
 		return NewPair(val.PairKey().Duplicate(), val.PairValue().Duplicate())
 	} else if val.IsContainer() {
 		dup := new(CborValue)
 		dup.ctype = val.ctype
+		dup.items = make([]*CborValue, 0, len(val.items))
 		for ele := val.ContainerFirst(); ele != nil; ele = val.ContainerNext(ele) {
 			dup.ContainerInsertTail(ele.Duplicate())
 		}
 		return dup
 	} else if val.ctype == CBOR_TYPE_BYTESTRING {
-		return NewBytestring(val.blob.Bytes())
+		return NewBytestring(val.blob)
+	} else if val.ctype == CBOR__TYPE_RAW {
+		return NewRaw(val.blob)
 	}
 	return nil
 }
 
+// ContainerInsertBefore puts val in front of elm, shifting the entries after
+// it like ContainerInsertHead.
 func (container *CborValue) ContainerInsertBefore(elm *CborValue, val *CborValue) {
 	if container.IsContainer() && elm != nil && elm.parent == container {
-		prev := elm.prev
-		elm.prev = val
-		if prev != nil {
-			prev.next = val
-		} else {
-			container.first = val
-		}
-		val.prev = prev
-		val.next = elm
-		val.parent = container
+		container.insert(container.entry_index(elm), val)
 	}
 }
 
+// ContainerInsertAfter puts val behind elm, shifting the entries after it
+// like ContainerInsertHead.
 func (container *CborValue) ContainerInsertAfter(elm *CborValue, val *CborValue) {
 	if container.IsContainer() && elm != nil && elm.parent == container {
-		next := elm.next
-		elm.next = val
-		if next != nil {
-			next.prev = val
-		} else {
-			container.last = val
-		}
-		val.prev = elm
-		val.next = next
-		val.parent = container
+		container.insert(container.entry_index(elm) + 1, val)
 	}
 }
 
 func (container *CborValue) ContainerFirst() *CborValue {
-	if container.IsContainer() {
-		return container.first
+	if container.IsContainer() && len(container.items) > 0 {
+		return container.items[0]
 	}
 	return nil
 }
 func (container *CborValue) ContainerLast() *CborValue {
-	if container.IsContainer() {
-		return container.last
+	if container.IsContainer() && len(container.items) > 0 {
+		return container.items[len(container.items) - 1]
 	}
 	return nil
 }
 
 func (container *CborValue) ContainerNext(val *CborValue) *CborValue {
-	if val != nil && container.IsContainer() && val.parent == container {
-		return val.next
+	if val != nil && container.IsContainer() && val.parent == container && container.entry_index(val) + 1 < len(container.items) {
+		return container.items[container.entry_index(val) + 1]
 	}
 	return nil
 }
 
 func (container *CborValue) ContainerPrev(val *CborValue) *CborValue {
-	if val != nil && container.IsContainer() && val.parent == container {
-		return val.prev
+	if val != nil && container.IsContainer() && val.parent == container && container.entry_index(val) > 0 {
+		return container.items[container.entry_index(val) - 1]
 	}
 	return nil
 }
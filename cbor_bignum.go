@@ -0,0 +1,329 @@
+package cbor
+
+import "math"
+import "math/big"
+import "reflect"
+import "strconv"
+import "strings"
+
+const (
+	CBOR_TAG_POS_BIGNUM       uint64 = 2  // byte string, unsigned big-endian
+	CBOR_TAG_NEG_BIGNUM       uint64 = 3  // byte string n for -1 - n
+	CBOR_TAG_DECIMAL_FRACTION uint64 = 4  // [exponent, mantissa], base 10
+	CBOR_TAG_BIGFLOAT         uint64 = 5  // [exponent, mantissa], base 2
+	CBOR_TAG_RATIONAL         uint64 = 30 // [numerator, denominator]
+)
+
+// decimal fractions and bigfloats whose exponent is larger are not expanded
+// into exact integers or rationals, which would take memory in proportion
+const big_exponent_max = 1 << 14
+
+var big_int_type = reflect.TypeOf(big.Int{})
+var big_float_type = reflect.TypeOf(big.Float{})
+var big_rat_type = reflect.TypeOf(big.Rat{})
+
+var big_one = big.NewInt(1)
+
+// NewBigInt returns i as a plain integer when it fits major type 0 or 1, and
+// as a bignum, tag 2 or 3, otherwise.
+func NewBigInt(i *big.Int) *CborValue {
+	if i.Sign() >= 0 {
+		if i.IsUint64() {
+			return NewUint64(i.Uint64())
+		}
+		return NewTagged(CBOR_TAG_POS_BIGNUM, NewBytestring(i.Bytes()))
+	}
+	n := new(big.Int).Sub(new(big.Int).Neg(i), big_one)
+	if n.IsUint64() {
+		return NewNegative(n.Uint64())
+	}
+	return NewTagged(CBOR_TAG_NEG_BIGNUM, NewBytestring(n.Bytes()))
+}
+
+// new_exponent_mantissa returns the content of a decimal fraction or bigfloat.
+func new_exponent_mantissa(number uint64, exponent int64, mantissa *big.Int) *CborValue {
+	content := NewArray()
+	content.ContainerInsertTail(NewInteger(exponent))
+	content.ContainerInsertTail(NewBigInt(mantissa))
+	return NewTagged(number, content)
+}
+
+// NewDecimalFraction returns mantissa * 10**exponent, tag 4.
+func NewDecimalFraction(exponent int64, mantissa *big.Int) *CborValue {
+	return new_exponent_mantissa(CBOR_TAG_DECIMAL_FRACTION, exponent, mantissa)
+}
+
+// NewBigFloat returns f exactly as a bigfloat, tag 5. An infinity becomes a
+// float.
+func NewBigFloat(f *big.Float) *CborValue {
+	if f.IsInf() {
+		return NewFloat(math.Inf(f.Sign()))
+	}
+	if f.Sign() == 0 {
+		return new_exponent_mantissa(CBOR_TAG_BIGFLOAT, 0, new(big.Int))
+	}
+	// f = m * 2**exp with 0.5 <= |m| < 1, scaling by its precision leaves an
+	// integer mantissa
+	exp := f.MantExp(nil)
+	prec := int(f.MinPrec())
+	mantissa, _ := new(big.Float).SetMantExp(f, prec - exp).Int(nil)
+	return new_exponent_mantissa(CBOR_TAG_BIGFLOAT, int64(exp - prec), mantissa)
+}
+
+// NewBigRat returns r as a rational number, tag 30.
+func NewBigRat(r *big.Rat) *CborValue {
+	content := NewArray()
+	content.ContainerInsertTail(NewBigInt(r.Num()))
+	content.ContainerInsertTail(NewBigInt(r.Denom()))
+	return NewTagged(CBOR_TAG_RATIONAL, content)
+}
+
+// BigInt returns the value of an integer or a bignum, or nil for any other
+// item.
+func (val *CborValue) BigInt() *big.Int {
+	if val.IsInteger() {
+		i := new(big.Int).SetUint64(val.num)
+		if val.ctype == CBOR_TYPE_NEGINT {
+			i.Sub(i.Neg(i), big_one)
+		}
+		return i
+	}
+	content := val.TagContent()
+	if content == nil || content.ctype != CBOR_TYPE_BYTESTRING {
+		return nil
+	}
+	if val.num == CBOR_TAG_POS_BIGNUM {
+		return new(big.Int).SetBytes(content.blob)
+	} else if val.num == CBOR_TAG_NEG_BIGNUM {
+		i := new(big.Int).SetBytes(content.blob)
+		return i.Sub(i.Neg(i), big_one)
+	}
+	return nil
+}
+
+// exponent_mantissa reads the content of a decimal fraction or bigfloat.
+func (val *CborValue) exponent_mantissa() (int64, *big.Int, bool) {
+	content := val.TagContent()
+	if !content.IsArray() || content.ContainerSize() != 2 {
+		return 0, nil, false
+	}
+	exponent, ok := int64_value(content.ContainerAt(0))
+	if !ok {
+		return 0, nil, false
+	}
+	mantissa := content.ContainerAt(1).BigInt()
+	return exponent, mantissa, mantissa != nil
+}
+
+// BigFloat returns the value of a number, an integer, float, bignum, decimal
+// fraction or bigfloat, or nil for any other item. A decimal fraction is
+// rounded to the precision of its mantissa, at least 64 bits, and is not
+// converted when its exponent exceeds 16384 in magnitude.
+func (val *CborValue) BigFloat() *big.Float {
+	if i := val.BigInt(); i != nil {
+		return new(big.Float).SetInt(i)
+	}
+	if val.IsFloat() {
+		if math.IsNaN(val.Float()) {
+			return nil
+		}
+		return big.NewFloat(val.Float())
+	}
+	if !val.IsTag() || (val.num != CBOR_TAG_DECIMAL_FRACTION && val.num != CBOR_TAG_BIGFLOAT) {
+		return nil
+	}
+	exponent, mantissa, ok := val.exponent_mantissa()
+	if !ok {
+		return nil
+	}
+	if val.num == CBOR_TAG_BIGFLOAT {
+		f := new(big.Float).SetInt(mantissa)
+		if exponent > math.MaxInt32 || exponent < math.MinInt32 {
+			// beyond the exponent range of big.Float
+			if exponent > 0 && mantissa.Sign() != 0 {
+				return f.SetInf(mantissa.Sign() < 0)
+			}
+			return f.SetInt64(0)
+		}
+		return f.SetMantExp(f, int(exponent))
+	}
+	r := val.BigRat()
+	if r == nil {
+		return nil
+	}
+	f := new(big.Float).SetPrec(uint(mantissa.BitLen()))
+	if f.Prec() < 64 {
+		f.SetPrec(64)
+	}
+	return f.SetRat(r)
+}
+
+// BigRat returns the exact value of a number, an integer, float, bignum,
+// decimal fraction, bigfloat or rational, or nil for any other item. Decimal
+// fractions and bigfloats whose exponent exceeds 16384 in magnitude are not
+// converted.
+func (val *CborValue) BigRat() *big.Rat {
+	if i := val.BigInt(); i != nil {
+		return new(big.Rat).SetInt(i)
+	}
+	if val.IsFloat() {
+		return new(big.Rat).SetFloat64(val.Float())
+	}
+	if !val.IsTag() {
+		return nil
+	}
+	switch val.num {
+	case CBOR_TAG_DECIMAL_FRACTION, CBOR_TAG_BIGFLOAT:
+		exponent, mantissa, ok := val.exponent_mantissa()
+		if !ok || exponent > big_exponent_max || exponent < -big_exponent_max {
+			return nil
+		}
+		base := big.NewInt(10)
+		if val.num == CBOR_TAG_BIGFLOAT {
+			base.SetInt64(2)
+		}
+		scale := new(big.Int).Exp(base, big.NewInt(abs_int64(exponent)), nil)
+		if exponent < 0 {
+			return new(big.Rat).SetFrac(mantissa, scale)
+		}
+		return new(big.Rat).SetInt(scale.Mul(scale, mantissa))
+	case CBOR_TAG_RATIONAL:
+		content := val.TagContent()
+		if !content.IsArray() || content.ContainerSize() != 2 {
+			return nil
+		}
+		num := content.ContainerAt(0).BigInt()
+		denom := content.ContainerAt(1).BigInt()
+		if num == nil || denom == nil || denom.Sign() <= 0 {
+			return nil
+		}
+		return new(big.Rat).SetFrac(num, denom)
+	}
+	return nil
+}
+
+func abs_int64(i int64) int64 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// is_big_tag reports whether number is one of the big number tags.
+func is_big_tag(number uint64) bool {
+	return number == CBOR_TAG_POS_BIGNUM || number == CBOR_TAG_NEG_BIGNUM || number == CBOR_TAG_DECIMAL_FRACTION ||
+		number == CBOR_TAG_BIGFLOAT || number == CBOR_TAG_RATIONAL
+}
+
+// big_interface converts a big number tag into the value an interface{}
+// receives: *big.Int for bignums, *big.Float for decimal fractions and
+// bigfloats, *big.Rat for rationals, or nil when the content is invalid.
+func (val *CborValue) big_interface() interface{} {
+	switch val.num {
+	case CBOR_TAG_POS_BIGNUM, CBOR_TAG_NEG_BIGNUM:
+		if i := val.BigInt(); i != nil {
+			return i
+		}
+	case CBOR_TAG_DECIMAL_FRACTION, CBOR_TAG_BIGFLOAT:
+		if f := val.BigFloat(); f != nil {
+			return f
+		}
+	case CBOR_TAG_RATIONAL:
+		if r := val.BigRat(); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// json_number writes an integer, bignum, decimal fraction or bigfloat as a
+// JSON number without rounding, reporting false for other items.
+func json_number(val *CborValue) (string, bool) {
+	if i := val.BigInt(); i != nil {
+		return i.String(), true
+	}
+	if !val.IsTag() || (val.num != CBOR_TAG_DECIMAL_FRACTION && val.num != CBOR_TAG_BIGFLOAT) {
+		return "", false
+	}
+	exponent, mantissa, ok := val.exponent_mantissa()
+	if !ok || (val.num == CBOR_TAG_BIGFLOAT && (exponent > big_exponent_max || exponent < -big_exponent_max)) {
+		if f := val.BigFloat(); f != nil && !f.IsInf() {
+			return f.Text('g', -1), true
+		}
+		return "", false
+	}
+	if val.num == CBOR_TAG_BIGFLOAT {
+		// m * 2**e is m << e, or m * 5**-e * 10**e
+		if exponent >= 0 {
+			return mantissa.Lsh(mantissa, uint(exponent)).String(), true
+		}
+		five := new(big.Int).Exp(big.NewInt(5), big.NewInt(-exponent), nil)
+		mantissa.Mul(mantissa, five)
+	}
+	if exponent == 0 {
+		return mantissa.String(), true
+	}
+	return mantissa.String() + "e" + strconv.FormatInt(exponent, 10), true
+}
+
+// json_decimal returns the number a JSON literal with a fraction or exponent
+// denotes: a float when the float reads back as the same decimal, and a
+// decimal fraction otherwise.
+func json_decimal(text string) (*CborValue, bool) {
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		exact, ok1 := new(big.Rat).SetString(text)
+		short, ok2 := new(big.Rat).SetString(strconv.FormatFloat(number, 'g', -1, 64))
+		if ok1 && ok2 && exact.Cmp(short) == 0 {
+			return NewFloat(number), true
+		}
+	}
+	digits := strings.TrimPrefix(text, "+")
+	var exponent int64 = 0
+	if e := strings.IndexAny(digits, "eE"); e >= 0 {
+		var err error
+		exponent, err = strconv.ParseInt(strings.TrimPrefix(digits[e+1:], "+"), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		digits = digits[:e]
+	}
+	if dot := strings.IndexByte(digits, '.'); dot >= 0 {
+		exponent -= int64(len(digits) - dot - 1)
+		digits = digits[:dot] + digits[dot+1:]
+	}
+	mantissa, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, false
+	}
+	return NewDecimalFraction(exponent, mantissa), true
+}
+
+// marshal_big encodes the math/big types, reporting false for other values.
+func marshal_big(v reflect.Value) (*CborValue, bool) {
+	t := v.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if (t != big_int_type && t != big_float_type && t != big_rat_type) || !v.CanInterface() {
+		return nil, false
+	}
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return NewNull(), true
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		v = v.Addr()
+	} else if v.Kind() != reflect.Ptr {
+		p := reflect.New(t)
+		p.Elem().Set(v)
+		v = p
+	}
+	switch x := v.Interface().(type) {
+	case *big.Int:
+		return NewBigInt(x), true
+	case *big.Float:
+		return NewBigFloat(x), true
+	case *big.Rat:
+		return NewBigRat(x), true
+	}
+	return nil, false
+}
@@ -0,0 +1,167 @@
+package cbor
+
+import "bytes"
+import "errors"
+import "math/big"
+import "testing"
+
+func TestBigInt(t *testing.T) {
+	cases := []struct {
+		number string
+		data   string
+	}{
+		{"0", "\x00"},
+		{"18446744073709551615", "\x1b\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{"18446744073709551616", "\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{"-18446744073709551616", "\x3b\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{"-18446744073709551617", "\xc3\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+	}
+	for _, c := range cases {
+		i, _ := new(big.Int).SetString(c.number, 10)
+		if buf := CBOREncode(NewBigInt(i)).Bytes(); !bytes.Equal(buf, []byte(c.data)) {
+			t.Errorf("%s: encode got %x", c.number, buf)
+		}
+		if data, err := Marshal(i); err != nil || !bytes.Equal(data, []byte(c.data)) {
+			t.Errorf("%s: marshal got %x, %v", c.number, data, err)
+		}
+		if data, err := Marshal(*i); err != nil || !bytes.Equal(data, []byte(c.data)) {
+			t.Errorf("%s: marshal value got %x, %v", c.number, data, err)
+		}
+		val, _ := CBORDecode([]byte(c.data))
+		if got := val.BigInt(); got == nil || got.Cmp(i) != 0 {
+			t.Errorf("%s: big int got %v", c.number, got)
+		}
+		var got *big.Int
+		if err := Unmarshal([]byte(c.data), &got); err != nil || got.Cmp(i) != 0 {
+			t.Errorf("%s: unmarshal got %v, %v", c.number, got, err)
+		}
+		if s := JSONEncode(val).String(); s != c.number {
+			t.Errorf("%s: json encode got %s", c.number, s)
+		}
+		if j, err := JSONDecode([]byte(c.number)); err != nil || !bytes.Equal(CBOREncode(j).Bytes(), []byte(c.data)) {
+			t.Errorf("%s: json decode got %x, %v", c.number, CBOREncode(j).Bytes(), err)
+		}
+	}
+
+	var any interface{}
+	if err := Unmarshal([]byte(cases[2].data), &any); err != nil || any.(*big.Int).String() != cases[2].number {
+		t.Errorf("unmarshal bignum into interface got %#v, %v", any, err)
+	}
+	if val, _ := CBORDecode([]byte(cases[4].data)); val.Float() != -18446744073709551617.0 {
+		t.Errorf("bignum float got %v", val.Float())
+	}
+	if NewString("1").BigInt() != nil || NewTagged(2, NewInteger(1)).BigInt() != nil {
+		t.Errorf("expected no big int for other items")
+	}
+	var i big.Int
+	var typeerr *UnmarshalTypeError
+	if err := Unmarshal([]byte("\x61\x31"), &i); !errors.As(err, &typeerr) {
+		t.Errorf("expected type error, got %v", err)
+	}
+	if err := Unmarshal([]byte("\xc2\x01"), &any); err != nil || any.(Tag).Number != 2 {
+		t.Errorf("invalid bignum got %#v, %v", any, err)
+	}
+}
+
+func TestBigFloat(t *testing.T) {
+	// RFC 8949 examples, 273.15 and 1.5
+	decimal := NewDecimalFraction(-2, big.NewInt(27315))
+	if buf := CBOREncode(decimal).Bytes(); !bytes.Equal(buf, []byte("\xc4\x82\x21\x19\x6a\xb3")) {
+		t.Errorf("decimal fraction got %x", buf)
+	}
+	if r := decimal.BigRat(); r == nil || r.Cmp(big.NewRat(27315, 100)) != 0 {
+		t.Errorf("decimal fraction rat got %v", r)
+	}
+	if decimal.Float() != 273.15 || JSONEncode(decimal).String() != "27315e-2" {
+		t.Errorf("decimal fraction got %v, %s", decimal.Float(), JSONEncode(decimal))
+	}
+	bigfloat := NewBigFloat(big.NewFloat(1.5))
+	if buf := CBOREncode(bigfloat).Bytes(); !bytes.Equal(buf, []byte("\xc5\x82\x20\x03")) {
+		t.Errorf("bigfloat got %x", buf)
+	}
+	if f := bigfloat.BigFloat(); f == nil || f.Cmp(big.NewFloat(1.5)) != 0 || JSONEncode(bigfloat).String() != "15e-1" {
+		t.Errorf("bigfloat got %v, %s", f, JSONEncode(bigfloat))
+	}
+
+	// a float beyond float64 survives a round trip exactly
+	huge, _ := new(big.Float).SetPrec(200).SetString("1e400")
+	data, err := Marshal(huge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f big.Float
+	if err := Unmarshal(data, &f); err != nil || f.Cmp(huge) != 0 {
+		t.Errorf("unmarshal bigfloat got %v, %v", &f, err)
+	}
+	var any interface{}
+	if err := Unmarshal(data, &any); err != nil || any.(*big.Float).Cmp(huge) != 0 {
+		t.Errorf("unmarshal bigfloat into interface got %#v, %v", any, err)
+	}
+	for _, item := range []*CborValue{NewInteger(-3), NewFloat(0.25), decimal} {
+		if item.BigFloat() == nil {
+			t.Errorf("%s: expected big float", JSONEncode(item))
+		}
+	}
+
+	// exponents too large to expand exactly
+	far := NewDecimalFraction(1000000, big.NewInt(12))
+	if far.BigRat() != nil || JSONEncode(far).String() != "12e1000000" {
+		t.Errorf("large exponent got %v, %s", far.BigRat(), JSONEncode(far))
+	}
+	if NewTagged(5, NewArray()).BigFloat() != nil || NewString("x").BigFloat() != nil {
+		t.Errorf("expected no big float for invalid content")
+	}
+}
+
+func TestBigRat(t *testing.T) {
+	third := big.NewRat(-1, 3)
+	data, err := Marshal(third)
+	if err != nil || !bytes.Equal(data, []byte("\xd8\x1e\x82\x20\x03")) {
+		t.Errorf("marshal rat got %x, %v", data, err)
+	}
+	var r big.Rat
+	if err := Unmarshal(data, &r); err != nil || r.Cmp(third) != 0 {
+		t.Errorf("unmarshal rat got %v, %v", &r, err)
+	}
+	// every number converts exactly
+	if err := Unmarshal([]byte("\xc5\x82\x20\x03"), &r); err != nil || r.Cmp(big.NewRat(3, 2)) != 0 {
+		t.Errorf("unmarshal bigfloat into rat got %v, %v", &r, err)
+	}
+	if err := Unmarshal([]byte("\xfb\x3f\xb9\x99\x99\x99\x99\x99\x9a"), &r); err != nil || r.Cmp(new(big.Rat).SetFloat64(0.1)) != 0 {
+		t.Errorf("unmarshal float into rat got %v, %v", &r, err)
+	}
+	val, _ := CBORDecode(data)
+	if val.Float() != -1.0 / 3 || JSONEncode(val).String() != "[-1, 3]" {
+		t.Errorf("rational got %v, %s", val.Float(), JSONEncode(val))
+	}
+	if NewTagged(30, NewArray()).BigRat() != nil || CBOREncode(NewBigRat(big.NewRat(4, 2))).String() != "\xd8\x1e\x82\x02\x01" {
+		t.Errorf("rational content fail")
+	}
+	bad, _ := CBORDecode([]byte("\xd8\x1e\x82\x01\x00"))
+	if bad.BigRat() != nil {
+		t.Errorf("expected no rat for a zero denominator")
+	}
+}
+
+func TestJSONNumbers(t *testing.T) {
+	val, err := JSONDecode([]byte(`[1.5, 0.1, 3.141592653589793238462643383279, 1e400, -2.5e-3]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !val.PointerGet("/0").IsFloat() || !val.PointerGet("/1").IsFloat() || !val.PointerGet("/4").IsFloat() {
+		t.Errorf("expected floats for exact decimals")
+	}
+	pi := val.PointerGet("/2")
+	if pi.TagNumber() != CBOR_TAG_DECIMAL_FRACTION || pi.Float() != 3.141592653589793 {
+		t.Errorf("expected decimal fraction for pi, got %s", JSONEncode(pi))
+	}
+	if s := JSONEncode(pi).String(); s != "3141592653589793238462643383279e-30" {
+		t.Errorf("json pi got %s", s)
+	}
+	if s := JSONEncode(val.PointerGet("/3")).String(); s != "1e400" {
+		t.Errorf("json 1e400 got %s", s)
+	}
+	if _, err := JSONDecode([]byte(`[1.2.3]`)); err == nil {
+		t.Errorf("expected error for an invalid number")
+	}
+}
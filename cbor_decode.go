@@ -1,8 +1,8 @@
 package cbor
 
-import "fmt"
 import "math"
-import "encoding/binary"
+import "bytes"
+import "unicode/utf8"
 
 func read_network_endian(buf []byte, offset int, size int) uint64 {
 	var integer uint64 = 0
@@ -13,363 +13,436 @@ func read_network_endian(buf []byte, offset int, size int) uint64 {
 	return integer
 }
 
-func cbor_parse(buf []byte, offset int) (*CborValue, error, int) {
-	var val *CborValue = nil
-	var err error = nil
-	var origin int = offset
-	ctype := int(uint32(buf[offset]) >> 5)
-	addition := int(uint32(buf[offset]) & 0x1F)
-	if ctype == CBOR_TYPE_UINT {
-		offset++
-		val = new(CborValue)
-		val.ctype = CBOR_TYPE_UINT
-		if addition < 24 {
-			val.integer = uint64(addition)
-		} else if addition == 24 && offset + 1 <= len(buf) {
-			val.integer = read_network_endian(buf, offset, 1)
-			offset++
-		} else if addition == 25 && offset + 2 <= len(buf) {
-			val.integer = read_network_endian(buf, offset, 2)
-			offset += 2
-		} else if addition == 26 && offset + 4 <= len(buf) {
-			val.integer = read_network_endian(buf, offset, 4)
-			offset += 4
-		} else if addition == 27 && offset + 8 <= len(buf) {
-			val.integer = read_network_endian(buf, offset, 8)
-			offset += 8
-		} else {
-			val = nil
-			err = fmt.Errorf("unknown addition value when decode unsigned integer")
+type cbor_head struct {
+	ctype    int
+	addition int
+	argument uint64
+}
+
+// read_head reads the initial byte of the item at offset and the argument
+// that follows it. An addition of 31 is returned as is, whether it is allowed
+// depends on the major type and is left to the caller.
+func read_head(buf []byte, offset int) (cbor_head, error, int) {
+	var head cbor_head
+	if offset >= len(buf) {
+		return head, syntax_error(offset, -1, CBOR_ERR_TRUNCATED), 0
+	}
+	head.ctype = int(uint32(buf[offset]) >> 5)
+	head.addition = int(uint32(buf[offset]) & 0x1F)
+	if head.addition < 24 {
+		head.argument = uint64(head.addition)
+		return head, nil, 1
+	} else if head.addition <= 27 {
+		size := 1 << uint(head.addition - 24)
+		if offset + 1 + size > len(buf) {
+			return head, syntax_error(offset, head.ctype, CBOR_ERR_TRUNCATED), 0
 		}
-	} else if ctype == CBOR_TYPE_NEGINT {
-		val = new(CborValue)
-		val.ctype = CBOR_TYPE_NEGINT
-		offset++
-		if addition < 24 {
-			val.integer = uint64(addition)
-		} else if addition == 24 && offset + 1 <= len(buf) {
-			val.integer = read_network_endian(buf, offset, 1)
-			offset++
-		} else if addition == 25 && offset + 2 <= len(buf) {
-			val.integer = read_network_endian(buf, offset, 2)
-			offset += 2
-		} else if addition == 26 && offset + 4 <= len(buf) {
-			val.integer = read_network_endian(buf, offset, 4)
-			offset += 4
-		} else if addition == 27 && offset + 8 <= len(buf) {
-			val.integer = read_network_endian(buf, offset, 8)
-			offset += 8
-		} else {
-			val = nil
-			err = fmt.Errorf("unknown addition value when decode integer")
+		head.argument = read_network_endian(buf, offset + 1, size)
+		return head, nil, 1 + size
+	} else if head.addition == 31 {
+		return head, nil, 1
+	}
+	return head, syntax_error(offset, head.ctype, CBOR_ERR_INVALID_ADDITIONAL_INFO), 0
+}
+
+func float16_to_float64(u16 uint16) float64 {
+	u64 := uint64(u16)
+	sign := (u64 & 0x8000) >> 15
+	exp := (u64 >> 10) & 0x1F
+	frac := u64 & 0x3FF
+
+	if exp == 0 {
+		// subnormal or zero
+		real := math.Ldexp(float64(frac), -24)
+		if sign == 1 {
+			real = -real
 		}
+		return real
+	}
+	u64 = frac << (52 - 10)
+	if sign == 1 {
+		u64 |= 1 << 63
+	}
+	if exp == 31 {
+		u64 |= 0x7FF << 52
+	} else {
+		u64 |= (exp - 15 + 1023) << 52
+	}
+	return math.Float64frombits(u64)
+}
 
-	} else if ctype == CBOR_TYPE_BYTESTRING {
-		val = new(CborValue)
-		val.ctype = CBOR_TYPE_BYTESTRING
-		offset++
-		var size uint64 = 0
-		if addition < 24 {
-			size = uint64(addition)
-		} else if addition == 24 && offset + 1 <= len(buf) {
-			size = read_network_endian(buf, offset, 1)
-			offset++
-		} else if addition == 25 && offset + 2 <= len(buf) {
-			size = read_network_endian(buf, offset, 2)
-			offset += 2
-		} else if addition == 26 && offset + 4 <= len(buf) {
-			size = read_network_endian(buf, offset, 4)
-			offset += 4
-		} else if addition == 27 && offset + 8 <= len(buf) {
-			size = read_network_endian(buf, offset, 8)
-			offset += 8
-		} else if addition == 31 {
-			for offset < len(buf) {
-				if buf[offset] == 0xFF {
-					offset++
-					break
-				}
-				subval, suberr, subconsume := cbor_parse(buf, offset)
-				if subval != nil && subval.ctype == CBOR_TYPE_BYTESTRING {
-					offset += subconsume
-					val.blob.Write(subval.blob.Bytes())
-				} else {
-					val = nil
-					err = suberr
-					break
-				}
+func float32_to_float64(u32 uint32) float64 {
+	u64 := uint64(u32)
+	sign := u64 >> 31
+	exp := (u64 >> 23) & 0xFF
+	frac := u64 & 0x7FFFFF
+
+	if exp == 0 {
+		return float64(math.Float32frombits(u32))
+	}
+	u64 = frac << (52 - 23)
+	if sign == 1 {
+		u64 |= 1 << 63
+	}
+	if exp == 255 {
+		u64 |= 0x7FF << 52
+	} else {
+		u64 |= (exp - 127 + 1023) << 52
+	}
+	return math.Float64frombits(u64)
+}
+
+var head_minimum = [4]uint64{24, 0x100, 0x10000, 0x100000000}
+
+// check_deterministic rejects heads that are not in the shortest form, floats
+// that are not in their preferred width, NaNs other than the canonical
+// 0xf97e00 the deterministic encoder writes and indefinite lengths.
+func check_deterministic(head cbor_head, offset int) error {
+	if head.addition == 31 {
+		return syntax_error(offset, head.ctype, CBOR_ERR_NOT_DETERMINISTIC)
+	}
+	if head.ctype == CBOR_TYPE_SIMPLE && head.addition >= 25 && head.addition <= 27 {
+		var real float64
+		if head.addition == 25 {
+			if head.argument & 0x7C00 == 0x7C00 && head.argument & 0x3FF != 0 && head.argument != 0x7E00 {
+				return syntax_error(offset, head.ctype, CBOR_ERR_NOT_DETERMINISTIC)
 			}
+			return nil
+		} else if head.addition == 26 {
+			real = float32_to_float64(uint32(head.argument))
 		} else {
-			val = nil
-			err = fmt.Errorf("unknown addition value when decode bytestring")
+			real = math.Float64frombits(head.argument)
 		}
-		if val != nil && addition != 31 && offset + int(size) <= len(buf) {
-			val.blob.Write(buf[offset:offset+int(size)])
-			offset += int(size)
+		if math.IsNaN(real) {
+			return syntax_error(offset, head.ctype, CBOR_ERR_NOT_DETERMINISTIC)
 		}
-	} else if ctype == CBOR_TYPE_STRING {
-		val = new(CborValue)
-		val.ctype = CBOR_TYPE_STRING
-		offset++
-		var size uint64 = 0
-		if addition < 24 {
-			size = uint64(addition)
-		} else if addition == 24 && offset + 1 <= len(buf) {
-			size = read_network_endian(buf, offset, 1)
-			offset++
-		} else if addition == 25 && offset + 2 <= len(buf) {
-			size = read_network_endian(buf, offset, 2)
-			offset += 2
-		} else if addition == 26 && offset + 4 <= len(buf) {
-			size = read_network_endian(buf, offset, 4)
-			offset += 4
-		} else if addition == 27 && offset + 8 <= len(buf) {
-			size = read_network_endian(buf, offset, 8)
-			offset += 8
-		} else if addition == 31 {
-			for offset < len(buf) {
-				if buf[offset] == 0xFF {
-					offset++
-					break
-				}
-				subval, suberr, subconsume := cbor_parse(buf, offset)
-				if subval != nil && subval.ctype == CBOR_TYPE_STRING {
-					offset += subconsume
-					val.blob.Write(subval.blob.Bytes())
-				} else {
-					val = nil
-					err = suberr
-					break
-				}
+		_, half := float64_to_float16(real)
+		_, single := float64_to_float32(real)
+		if half || (single && head.addition == 27) {
+			return syntax_error(offset, head.ctype, CBOR_ERR_NOT_DETERMINISTIC)
+		}
+	} else if head.addition >= 24 && head.argument < head_minimum[head.addition - 24] {
+		return syntax_error(offset, head.ctype, CBOR_ERR_NOT_DETERMINISTIC)
+	}
+	return nil
+}
+
+// node_slab hands out the nodes and entry slices of a document from chunks,
+// so that decoding costs a few allocations rather than one per node. A node
+// kept after the rest of its document is dropped keeps its chunk alive.
+type node_slab struct {
+	nodes []CborValue
+	ptrs  []*CborValue
+	chunk int
+}
+
+const slab_chunk_min = 16
+const slab_chunk_max = 1024
+
+func (slab *node_slab) grow() int {
+	if slab.chunk < slab_chunk_min {
+		slab.chunk = slab_chunk_min
+	} else if slab.chunk < slab_chunk_max {
+		slab.chunk *= 2
+	}
+	return slab.chunk
+}
+
+func (slab *node_slab) node(ctype int) *CborValue {
+	if len(slab.nodes) == 0 {
+		slab.nodes = make([]CborValue, slab.grow())
+	}
+	val := &slab.nodes[0]
+	slab.nodes = slab.nodes[1:]
+	val.ctype = ctype
+	return val
+}
+
+// items returns an empty entry slice with room for n entries, appending
+// past them reallocates instead of running into the next slice.
+func (slab *node_slab) items(n int) []*CborValue {
+	if n > slab_chunk_max / 4 {
+		return make([]*CborValue, 0, n)
+	}
+	if len(slab.ptrs) < n {
+		size := slab.grow()
+		if size < n {
+			size = n
+		}
+		slab.ptrs = make([]*CborValue, size)
+	}
+	items := slab.ptrs[0:0:n]
+	slab.ptrs = slab.ptrs[n:]
+	return items
+}
+
+// read_item_head reads the head of the data item at offset and applies the
+// checks that concern the head alone: its additional information, the
+// DecodeOptions limits and, in Strict mode, the shortest form.
+func read_item_head(buf []byte, offset int, opts *DecodeOptions, depth int) (cbor_head, error, int) {
+	head, err, consume := read_head(buf, offset)
+	if err != nil {
+		return head, err, 0
+	}
+	ctype := head.ctype
+	addition := head.addition
+	if addition == 31 && (ctype == CBOR_TYPE_UINT || ctype == CBOR_TYPE_NEGINT || ctype == CBOR_TYPE_TAG) {
+		return head, syntax_error(offset, ctype, CBOR_ERR_INVALID_ADDITIONAL_INFO), 0
+	}
+	if err = opts.check_head(head, offset, depth); err != nil {
+		return head, err, 0
+	}
+	if opts.Strict {
+		if err = check_deterministic(head, offset); err != nil {
+			return head, err, 0
+		}
+	}
+	if ctype == CBOR_TYPE_SIMPLE {
+		if addition == 24 && head.argument < 32 {
+			return head, syntax_error(offset, ctype, CBOR_ERR_INVALID_ADDITIONAL_INFO), 0
+		} else if addition >= 28 {
+			return head, syntax_error(offset, ctype, CBOR_ERR_UNEXPECTED_BREAK), 0
+		}
+	}
+	return head, nil, consume
+}
+
+// walk_string calls chunk with the bounds of the content of the string whose
+// head at origin ends at offset, once for a definite length and once per
+// chunk for an indefinite one, and returns the offset following the string.
+func walk_string(buf []byte, offset int, head cbor_head, origin int, opts *DecodeOptions, depth int, chunk func(start, end int)) (error, int) {
+	ctype := head.ctype
+	if head.addition != 31 {
+		if head.argument > uint64(len(buf) - offset) {
+			return syntax_error(origin, ctype, CBOR_ERR_TRUNCATED), 0
+		}
+		blob := buf[offset:offset+int(head.argument)]
+		if ctype == CBOR_TYPE_STRING && !utf8.Valid(blob) {
+			return syntax_error(origin, ctype, CBOR_ERR_INVALID_UTF8), 0
+		}
+		chunk(offset, offset + len(blob))
+		return nil, offset + len(blob)
+	}
+	var length uint64 = 0
+	for {
+		if offset < len(buf) && buf[offset] == 0xFF {
+			return nil, offset + 1
+		}
+		if offset < len(buf) && (int(buf[offset] >> 5) != ctype || buf[offset] & 0x1F == 31) {
+			return syntax_error(offset, ctype, CBOR_ERR_INVALID_CHUNK), 0
+		}
+		sub, err, consume := read_item_head(buf, offset, opts, depth)
+		if err != nil {
+			return err, 0
+		}
+		if sub.argument > uint64(len(buf) - offset - consume) {
+			return syntax_error(offset, ctype, CBOR_ERR_TRUNCATED), 0
+		}
+		blob := buf[offset+consume:offset+consume+int(sub.argument)]
+		if ctype == CBOR_TYPE_STRING && !utf8.Valid(blob) {
+			return syntax_error(offset, ctype, CBOR_ERR_INVALID_UTF8), 0
+		}
+		offset += consume + len(blob)
+		length += sub.argument
+		if err = opts.check_length(ctype, length, origin); err != nil {
+			return err, 0
+		}
+		chunk(offset - len(blob), offset)
+	}
+}
+
+// walk_entries calls item for each element of the array or map whose head
+// at origin ends at offset, twice per map entry for the key and the value.
+// It checks what concerns the container itself, an indefinite length
+// against the limits and, in Strict mode, the order of the map keys, and
+// returns the offset following the container.
+func walk_entries(buf []byte, offset int, head cbor_head, origin int, opts *DecodeOptions, item func(offset int) (error, int)) (error, int) {
+	ctype := head.ctype
+	var prev_key []byte = nil
+	for i := uint64(0); head.addition == 31 || i < head.argument; i++ {
+		if head.addition == 31 && offset < len(buf) && buf[offset] == 0xFF {
+			return nil, offset + 1
+		}
+		if head.addition == 31 {
+			if err := opts.check_length(ctype, i + 1, origin); err != nil {
+				return err, 0
 			}
-		} else {
-			val = nil
-			err = fmt.Errorf("unknown addition value when decode string")
 		}
-		if addition != 31 && offset + int(size) <= len(buf) {
-			val.blob.Write(buf[offset:offset+int(size)])
-			offset += int(size)
+		err, consume := item(offset)
+		if err != nil {
+			return err, 0
 		}
-	} else if ctype == CBOR_TYPE_ARRAY {
-		val = NewArray()
-		offset++
-		var size uint64 = 0
-		if addition < 24 {
-			size = uint64(addition)
-		} else if addition == 24 && offset + 1 <= len(buf) {
-			size = read_network_endian(buf, offset, 1)
-			offset++
-		} else if addition == 25 && offset + 2 <= len(buf) {
-			size = read_network_endian(buf, offset, 2)
-			offset += 2
-		} else if addition == 26 && offset + 4 <= len(buf) {
-			size = read_network_endian(buf, offset, 4)
-			offset += 4
-		} else if addition == 27 && offset + 8 <= len(buf) {
-			size = read_network_endian(buf, offset, 8)
-			offset += 8
-		} else if addition == 31 {
-			for offset < len(buf) {
-				if buf[offset] == 0xFF {
-					offset++
-					break
-				}
-				subval, suberr, subconsume := cbor_parse(buf, offset)
-				if subval != nil {
-					offset += subconsume
-					val.ContainerInsertTail(subval)
-				} else {
-					val = nil
-					err = suberr
-					break
-				}
+		if ctype == CBOR_TYPE_MAP && opts.Strict {
+			key := buf[offset:offset+consume]
+			if cmp := bytes.Compare(prev_key, key); i > 0 && cmp == 0 {
+				return syntax_error(offset, int(key[0] >> 5), CBOR_ERR_DUPLICATE_KEY), 0
+			} else if i > 0 && cmp > 0 {
+				return syntax_error(offset, int(key[0] >> 5), CBOR_ERR_NOT_DETERMINISTIC), 0
 			}
-		} else {
-			val = nil
-			err = fmt.Errorf("unknown addition value when decode array")
+			prev_key = key
 		}
-		if val != nil && addition != 31 {
-			for i := 0; i < int(size) && offset < len(buf); i++ {
-				subval, suberr, subconsume := cbor_parse(buf, offset)
-				if subval != nil {
-					offset += subconsume
-					val.ContainerInsertTail(subval)
-				} else {
-					val = nil
-					err = suberr
-					break
-				}
+		offset += consume
+		if ctype == CBOR_TYPE_MAP {
+			err, consume = item(offset)
+			if err != nil {
+				return err, 0
 			}
+			offset += consume
 		}
-	} else if ctype == CBOR_TYPE_MAP {
-		val = NewMap()
-		offset++
-		var size uint64 = 0
-		if addition < 24 {
-			size = uint64(addition)
-		} else if addition == 24 && offset + 1 <= len(buf) {
-			size = read_network_endian(buf, offset, 1)
-			offset++
-		} else if addition == 25 && offset + 2 <= len(buf) {
-			size = read_network_endian(buf, offset, 2)
-			offset += 2
-		} else if addition == 26 && offset + 4 <= len(buf) {
-			size = read_network_endian(buf, offset, 4)
-			offset += 4
-		} else if addition == 27 && offset + 8 <= len(buf) {
-			size = read_network_endian(buf, offset, 8)
-			offset += 8
-		} else if addition == 31 {
-			for offset < len(buf) {
-				if buf[offset] == 0xFF {
-					offset++
-					break
-				}
-				subkey, suberr, subconsume := cbor_parse(buf, offset)
-				if subkey != nil {
-					offset += subconsume
-					subval, suberr, subconsume := cbor_parse(buf, offset)
-					if subval != nil {
-						offset += subconsume
-						pair := NewPair(subkey, subval)
-						val.ContainerInsertTail(pair)
-					} else {
-						val = nil
-						err = suberr
-						break
-					}
-				} else {
-					val = nil
-					err = suberr
-					break
-				}
+	}
+	return nil, offset
+}
+
+func cbor_parse(buf []byte, offset int, opts *DecodeOptions, depth int) (*CborValue, error, int) {
+	return new(node_slab).parse(buf, offset, opts, depth)
+}
+
+func (slab *node_slab) parse(buf []byte, offset int, opts *DecodeOptions, depth int) (*CborValue, error, int) {
+	var val *CborValue = nil
+	var origin int = offset
+	head, err, consume := read_item_head(buf, offset, opts, depth)
+	if err != nil {
+		return nil, err, 0
+	}
+	offset += consume
+	ctype := head.ctype
+	addition := head.addition
+
+	if ctype == CBOR_TYPE_UINT || ctype == CBOR_TYPE_NEGINT {
+		val = slab.node(ctype)
+		val.num = head.argument
+	} else if ctype == CBOR_TYPE_BYTESTRING || ctype == CBOR_TYPE_STRING {
+		val = slab.node(ctype)
+		err, offset = walk_string(buf, offset, head, origin, opts, depth, func(start, end int) {
+			if addition != 31 && opts.ZeroCopy {
+				val.blob = buf[start:end:end]
+			} else if addition != 31 {
+				val.blob = append([]byte{}, buf[start:end]...)
+			} else {
+				val.blob = append(val.blob, buf[start:end]...)
 			}
-		} else {
-			val = nil
-			err = fmt.Errorf("unknown addition value when decode map")
+		})
+		if err != nil {
+			return nil, err, 0
 		}
-		if val != nil && addition != 31 {
-			for i := 0; i < int(size) && offset < len(buf); i++ {
-				subkey, suberr, subconsume := cbor_parse(buf, offset)
-				if subkey != nil {
-					offset += subconsume
-					subval, suberr, subconsume := cbor_parse(buf, offset)
-					if subval != nil {
-						offset += subconsume
-						pair := NewPair(subkey, subval)
-						val.ContainerInsertTail(pair)
-					} else {
-						val = nil
-						err = suberr
-						break
-					}
-				} else {
-					val = nil
-					err = suberr
-					break
-				}
+	} else if ctype == CBOR_TYPE_ARRAY || ctype == CBOR_TYPE_MAP {
+		val = slab.node(ctype)
+		if addition != 31 {
+			// every entry takes at least a byte, which bounds what a
+			// hostile head can make us reserve
+			size := uint64(len(buf) - offset)
+			if ctype == CBOR_TYPE_MAP {
+				size /= 2
 			}
+			if head.argument < size {
+				size = head.argument
+			}
+			val.items = slab.items(int(size))
+		}
+		var key *CborValue = nil
+		err, offset = walk_entries(buf, offset, head, origin, opts, func(offset int) (error, int) {
+			sub, err, consume := slab.parse(buf, offset, opts, depth + 1)
+			if err != nil {
+				return err, 0
+			}
+			if ctype == CBOR_TYPE_MAP && key == nil {
+				key = sub
+				return nil, consume
+			} else if ctype == CBOR_TYPE_MAP {
+				pair := slab.node(CBOR__TYPE_PAIR)
+				pair.items = slab.items(2)
+				pair.adopt(key)
+				pair.adopt(sub)
+				sub = pair
+				key = nil
+			}
+			val.adopt(sub)
+			return nil, consume
+		})
+		if err != nil {
+			return nil, err, 0
 		}
 	} else if ctype == CBOR_TYPE_TAG {
-		val = NewTag()
-		offset++
-		if addition < 24 {
-			val.tag_item = uint64(addition);
-		} else if addition == 24 && offset + 1 <= len(buf) {
-			val.tag_item = read_network_endian(buf, offset, 1)
-			offset++
-		} else if addition == 25 && offset + 2 <= len(buf) {
-			val.tag_item = read_network_endian(buf, offset, 2)
-			offset += 2
-		} else if addition == 26 && offset + 4 <= len(buf) {
-			val.tag_item = read_network_endian(buf, offset, 4)
-			offset += 4
-		} else if addition == 27 && offset + 8 <= len(buf) {
-			val.tag_item = read_network_endian(buf, offset, 8)
-			offset += 8
+		val = slab.node(ctype)
+		val.num = head.argument
+		content, suberr, subconsume := slab.parse(buf, offset, opts, depth + 1)
+		if suberr != nil {
+			return nil, suberr, 0
 		}
-		content, err, consume := cbor_parse(buf, offset)
-		if content != nil && offset + consume <= len(buf) {
-			offset += consume
-			val.tag_content = content
-		} else {
-			err = err
-			val = nil
+		offset += subconsume
+		if entry := opts.Tags.decoder(head.argument); entry != nil {
+			converted, err := entry.convert(content, origin)
+			if err != nil {
+				return nil, err, 0
+			}
+			val.converted = converted.Interface()
 		}
+		val.items = slab.items(1)
+		val.adopt(content)
 	} else if ctype == CBOR_TYPE_SIMPLE {
-		offset++
-		if addition < 20 {
-			val = NewUndef()
+		val = slab.node(ctype)
+		if addition < 24 {
 			val.ctrl = addition
-		} else if addition == 20 {
-			val = NewBoolean(false)
-		} else if addition == 21 {
-			val = NewBoolean(true)
-		} else if addition == 22 {
-			val = NewNull()
-		} else if addition == 23 {
-			val = NewUndef()
-		} else if addition == 24 && offset + 1 <= len(buf) {
-			val = NewExt()
-			val.ctrl = int(read_network_endian(buf, offset, 1))
-			offset += 1
-		} else if addition == 25 && offset + 2 <= len(buf) {
-			// float16
-			u64 := uint64(binary.BigEndian.Uint16(buf[offset:]))
-			sign := (u64 & 0x8000) >> 15
-			exp := (u64 >> 10) & 0x1F
-			frac := u64 & 0x3FF
-
-			u64 = frac << (52 - 10)
-			if sign == 1 {
-				u64 |= 1 << 63
-			}
-			if exp == 0 {
-
-			} else if exp == 31 {
-				u64 |= 0x7FF << 52
-			} else {
-				u64 |= (exp - 15 + 1023) << 52
-			}
-			offset += 2
-			val = NewFloat(math.Float64frombits(u64))
-		} else if addition == 26 && offset + 4 <= len(buf) {
-			// float32
-			u64 := uint64(binary.BigEndian.Uint32(buf[offset:]))
-			sign := u64 >> 31
-			exp := (u64 >> 23) & 0xFF
-			frac := u64 & 0x7FFFFF
-
-			u64 = frac << (52 - 23)
-			if sign == 1 {
-				u64 |= 1 << 63
-			}
-			if exp == 0 {
-
-			} else if exp == 255 {
-				u64 |= 0x7FF << 52
+		} else if addition == 24 {
+			val.ctrl = int(head.argument)
+		} else {
+			val.ctrl = CBOR_SIMPLE_REAL
+			if addition == 25 {
+				val.num = math.Float64bits(float16_to_float64(uint16(head.argument)))
+			} else if addition == 26 {
+				val.num = math.Float64bits(float32_to_float64(uint32(head.argument)))
 			} else {
-				u64 |= (exp - 127 + 1023) << 52
+				val.num = head.argument
 			}
-			offset += 4
-			val = NewFloat(math.Float64frombits(u64))
-		} else if addition == 27 && offset + 8 <= len(buf) {
-			// float64
-			u64 := uint64(binary.BigEndian.Uint64(buf[offset:]))
-			offset += 8
-			val = NewFloat(math.Float64frombits(u64))
-		} else {
-			val = nil
-			err = fmt.Errorf("unknown addition value when decode simple value")
 		}
-	} else {
-		val = nil
-		err = fmt.Errorf("unknown decode cbor type")
 	}
-	return val, err, offset - origin
+	return val, nil, offset - origin
 }
 
 func CBORDecode(buf []byte) (val *CborValue, err error) {
-	val, err, _ = cbor_parse(buf, 0)
-	return
+	return DecodeOptions{}.Decode(buf)
+}
+
+func CBORDecodeFirst(buf []byte) (val *CborValue, rest []byte, err error) {
+	return DecodeOptions{}.DecodeFirst(buf)
+}
+
+// Skip returns the length of the first data item of buf without allocating,
+// which splits a CBOR sequence into its items.
+func Skip(buf []byte) (int, error) {
+	return DecodeOptions{}.Skip(buf)
+}
+
+// Valid checks that buf holds exactly one well-formed data item without
+// allocating.
+func Valid(buf []byte) error {
+	return DecodeOptions{}.Valid(buf)
+}
+
+// cbor_skip checks the data item at offset the way cbor_parse does, without
+// building it, and returns its length.
+func cbor_skip(buf []byte, offset int, opts *DecodeOptions, depth int) (int, error) {
+	var origin int = offset
+	head, err, consume := read_item_head(buf, offset, opts, depth)
+	if err != nil {
+		return 0, err
+	}
+	offset += consume
+	ctype := head.ctype
+
+	if ctype == CBOR_TYPE_BYTESTRING || ctype == CBOR_TYPE_STRING {
+		err, offset = walk_string(buf, offset, head, origin, opts, depth, func(int, int) {})
+	} else if ctype == CBOR_TYPE_ARRAY || ctype == CBOR_TYPE_MAP {
+		err, offset = walk_entries(buf, offset, head, origin, opts, func(offset int) (error, int) {
+			consume, err := cbor_skip(buf, offset, opts, depth + 1)
+			return err, consume
+		})
+	} else if ctype == CBOR_TYPE_TAG {
+		consume, err = cbor_skip(buf, offset, opts, depth + 1)
+		offset += consume
+	}
+	if err != nil {
+		return 0, err
+	}
+	return offset - origin, nil
 }
@@ -1,5 +1,9 @@
 package cbor
 
+import "bytes"
+import "errors"
+import "math"
+import "strings"
 import "testing"
 
 var content = []string{
@@ -48,7 +52,7 @@ var content = []string{
     "\xf6",
     "\xf7",
     "\xf0",
-    "\xf8\x18",
+    "\xf8\x20",
     "\xf8\xff",
     "\xc0\x74\x32\x30\x31\x33\x2d\x30\x33\x2d\x32\x31\x54\x32\x30\x3a\x30\x34\x3a\x30\x30\x5a",
     "\xc1\x1a\x51\x4b\x67\xb0",
@@ -121,3 +125,320 @@ func TestNetworkEndian(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSyntaxError(t *testing.T) {
+	malformed := []struct {
+		item   string
+		offset int
+		ctype  int
+		kind   ErrorKind
+	}{
+		{"\x1c", 0, CBOR_TYPE_UINT, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+		{"\x19\x01", 0, CBOR_TYPE_UINT, CBOR_ERR_TRUNCATED},
+		{"\x81\x3d", 1, CBOR_TYPE_NEGINT, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+		{"\x82\x01\xff", 2, CBOR_TYPE_SIMPLE, CBOR_ERR_UNEXPECTED_BREAK},
+		{"\x62\xc3\x28", 0, CBOR_TYPE_STRING, CBOR_ERR_INVALID_UTF8},
+		{"\x5f\x61\x61\xff", 1, CBOR_TYPE_BYTESTRING, CBOR_ERR_INVALID_CHUNK},
+		{"\xa1\x01\xfe", 2, CBOR_TYPE_SIMPLE, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+		{"\xc1\xfc", 1, CBOR_TYPE_SIMPLE, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+		{"\xdc\x01", 0, CBOR_TYPE_TAG, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+		{"\xf8\x18", 0, CBOR_TYPE_SIMPLE, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+		{"\x81\xf8\x1f", 1, CBOR_TYPE_SIMPLE, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+	}
+	for _, m := range malformed {
+		val, err := CBORDecode([]byte(m.item))
+		var syntax *SyntaxError
+		if val != nil || !errors.As(err, &syntax) {
+			t.Errorf("%#v: expected syntax error, got %v", []byte(m.item), err)
+			continue
+		}
+		if syntax.Offset != m.offset || syntax.MajorType != m.ctype || syntax.Kind != m.kind {
+			t.Errorf("%#v: unexpected error %v", []byte(m.item), err)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for idx, item := range content {
+		for i := 0; i < len(item); i++ {
+			val, err := CBORDecode([]byte(item[:i]))
+			var syntax *SyntaxError
+			if val != nil || !errors.As(err, &syntax) || syntax.Kind != CBOR_ERR_TRUNCATED {
+				t.Errorf("%d. truncated at %d: expected truncated error, got %v", idx, i, err)
+			}
+		}
+	}
+
+	for _, item := range []string{"\x5b\xff\xff\xff\xff\xff\xff\xff\xff\x01", "\x9b\xff\xff\xff\xff\xff\xff\xff\xff\x01", "\xbf\x01\x02\x03"} {
+		if _, err := CBORDecode([]byte(item)); err == nil {
+			t.Errorf("%#v: expected error for overlong item", []byte(item))
+		}
+	}
+}
+
+func TestDecodeFirst(t *testing.T) {
+	val, err := CBORDecode([]byte("\x01\x02"))
+	var syntax *SyntaxError
+	if val != nil || !errors.As(err, &syntax) || syntax.Kind != CBOR_ERR_EXTRANEOUS_DATA || syntax.Offset != 1 {
+		t.Errorf("expected extraneous data error, got %v", err)
+	}
+
+	val, rest, err := CBORDecodeFirst([]byte("\x82\x01\x02\x03"))
+	if err != nil || val.ContainerSize() != 2 || len(rest) != 1 || rest[0] != 0x03 {
+		t.Log("decode first item fail")
+		t.Fail()
+	}
+}
+
+func TestDecodeContainerSizes(t *testing.T) {
+	for _, size := range []int{0, 1, 15, 16, 17, 100, 255, 256, 257, 1000, 5000} {
+		array := NewArray()
+		object := NewMap()
+		for i := 0; i < size; i++ {
+			array.ContainerInsertTail(NewInteger(int64(i)))
+			object.ContainerInsertTail(NewPair(NewInteger(int64(i)), NewArray()))
+		}
+		outer := NewArray()
+		outer.ContainerInsertTail(array)
+		outer.ContainerInsertTail(object)
+		outer.ContainerInsertTail(array)
+
+		buf := CBOREncode(outer).Bytes()
+		val, err := CBORDecode(buf)
+		if err != nil || !bytes.Equal(CBOREncode(val).Bytes(), buf) {
+			t.Errorf("%d. decode containers fail: %v", size, err)
+			continue
+		}
+		decoded := val.PointerGet("/0")
+		if decoded.ContainerSize() != size {
+			t.Errorf("%d. decoded size %d", size, decoded.ContainerSize())
+		}
+		// appending past the reserved entries must not clobber a neighbour
+		decoded.ContainerInsertTail(NewInteger(-1))
+		if val.PointerGet("/1").ContainerSize() != size || val.PointerGet("/2").ContainerSize() != size {
+			t.Errorf("%d. append clobbered a neighbouring container", size)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	sequence := []byte{}
+	for idx, item := range content {
+		if n, err := Skip([]byte(item)); err != nil || n != len(item) {
+			t.Errorf("%d. skip got %d, %v", idx, n, err)
+		}
+		if err := Valid([]byte(item)); err != nil {
+			t.Errorf("%d. valid fail: %v", idx, err)
+		}
+		sequence = append(sequence, item...)
+	}
+
+	count := 0
+	for rest := sequence; len(rest) > 0; count++ {
+		n, err := Skip(rest)
+		if err != nil {
+			t.Fatalf("skip sequence fail: %v", err)
+		}
+		rest = rest[n:]
+	}
+	if count != len(content) {
+		t.Errorf("split sequence into %d items, expected %d", count, len(content))
+	}
+	if allocs := testing.AllocsPerRun(10, func() { Valid([]byte(content[len(content) - 1])) }); allocs != 0 {
+		t.Errorf("valid allocated %v times", allocs)
+	}
+
+	var syntax *SyntaxError
+	if err := Valid([]byte("\x01\x02")); !errors.As(err, &syntax) || syntax.Kind != CBOR_ERR_EXTRANEOUS_DATA {
+		t.Errorf("expected extraneous data error, got %v", err)
+	}
+	if _, err := Skip([]byte("\x82\x01")); !errors.As(err, &syntax) || syntax.Kind != CBOR_ERR_TRUNCATED {
+		t.Errorf("expected truncated error, got %v", err)
+	}
+	var limit *LimitError
+	if err := (DecodeOptions{MaxNestingDepth: 1}).Valid([]byte("\x81\x81\x01")); !errors.As(err, &limit) {
+		t.Errorf("expected limit error, got %v", err)
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	limits := []struct {
+		item  string
+		opts  DecodeOptions
+		limit string
+	}{
+		{"\x81\x81\x01", DecodeOptions{MaxNestingDepth: 1}, "MaxNestingDepth"},
+		{"\x81\xc1\x01", DecodeOptions{MaxNestingDepth: 1}, "MaxNestingDepth"},
+		{"\x9b\xff\xff\xff\xff\xff\xff\xff\xff", DecodeOptions{MaxArrayElements: 16}, "MaxArrayElements"},
+		{"\x9f\x01\x02\x03\xff", DecodeOptions{MaxArrayElements: 2}, "MaxArrayElements"},
+		{"\xa2\x01\x02\x03\x04", DecodeOptions{MaxMapPairs: 1}, "MaxMapPairs"},
+		{"\x5b\xff\xff\xff\xff\xff\xff\xff\xff", DecodeOptions{MaxStringLength: 1024}, "MaxStringLength"},
+		{"\x7f\x62\x61\x61\x62\x61\x61\xff", DecodeOptions{MaxStringLength: 3}, "MaxStringLength"},
+		{"\x83\x01\x02\x03", DecodeOptions{MaxTotalBytes: 3}, "MaxTotalBytes"},
+	}
+	for _, l := range limits {
+		val, err := l.opts.Decode([]byte(l.item))
+		var limit *LimitError
+		if val != nil || !errors.As(err, &limit) || limit.Limit != l.limit {
+			t.Errorf("%#v: expected %s error, got %v", []byte(l.item), l.limit, err)
+		}
+		_, err = l.opts.NewDecoder(bytes.NewReader([]byte(l.item))).Decode()
+		if !errors.As(err, &limit) || limit.Limit != l.limit {
+			t.Errorf("%#v: expected %s error from stream, got %v", []byte(l.item), l.limit, err)
+		}
+	}
+
+	opts := DecodeOptions{MaxNestingDepth: 2, MaxStringLength: 4, MaxArrayElements: 3, MaxMapPairs: 1, MaxTotalBytes: 16}
+	if _, err := opts.Decode([]byte("\x83\x81\x01\x44\x01\x02\x03\x04\xa1\x01\x02")); err != nil {
+		t.Errorf("decode within limits fail: %v", err)
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	strict := DecodeOptions{Strict: true}
+	for idx, item := range content {
+		if idx >= 34 && idx < 40 || idx > 70 {
+			continue
+		}
+		if _, err := strict.Decode([]byte(item)); err != nil {
+			t.Errorf("%d. strict decode fail: %v", idx, err)
+		}
+	}
+
+	violations := []struct {
+		item   string
+		offset int
+		kind   ErrorKind
+	}{
+		{"\x18\x17", 0, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\x82\x01\x19\x00\xff", 2, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\x5a\x00\x00\x00\x01\x00", 0, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\xfa\x3f\x80\x00\x00", 0, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\xfb\x3f\xf1\x99\x99\xa0\x00\x00\x00", 0, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\x9f\x01\xff", 0, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\xf9\x7e\x01", 0, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\xf9\xfe\x00", 0, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\x81\xf9\x7c\x01", 1, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\xfa\x7f\xc0\x00\x00", 0, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\xfb\x7f\xf8\x00\x00\x00\x00\x00\x01", 0, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\xa2\x61\x62\x01\x61\x61\x02", 4, CBOR_ERR_NOT_DETERMINISTIC},
+		{"\xa2\x0a\x01\x0a\x02", 3, CBOR_ERR_DUPLICATE_KEY},
+	}
+	for _, v := range violations {
+		val, err := strict.Decode([]byte(v.item))
+		var syntax *SyntaxError
+		if val != nil || !errors.As(err, &syntax) || syntax.Kind != v.kind || syntax.Offset != v.offset {
+			t.Errorf("%#v: expected %s at %d, got %v", []byte(v.item), v.kind, v.offset, err)
+		}
+		if _, err = CBORDecode([]byte(v.item)); err != nil {
+			t.Errorf("%#v: decode fail: %v", []byte(v.item), err)
+		}
+	}
+
+	val := New(map[string]interface{}{"b": 1.5, "a": []interface{}{100000, "x", math.NaN()}, "aa": nil})
+	buf := EncodeOptions{Mode: CBOR_ENCODE_CORE_DETERMINISTIC}.Encode(val)
+	if _, err := strict.Decode(buf.Bytes()); err != nil {
+		t.Errorf("strict decode of deterministic encoding fail: %v", err)
+	}
+}
+
+func TestDecodeZeroCopy(t *testing.T) {
+	buf := []byte("\x83\x43abc\x62de\x5f\x41f\xff")
+	val, err := DecodeOptions{ZeroCopy: true}.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := val.PointerGet("/0")
+	if &first.StringBytes()[0] != &buf[2] {
+		t.Errorf("zero copy string does not reference the input")
+	}
+	if &val.PointerGet("/2").StringBytes()[0] == &buf[10] {
+		t.Errorf("indefinite-length string references the input")
+	}
+
+	first.BlobAppend("xyz")
+	first.BlobAppendByte('!')
+	if first.String() != "abcxyz!" || !bytes.Equal(buf, []byte("\x83\x43abc\x62de\x5f\x41f\xff")) {
+		t.Errorf("append wrote through to the input: %q, %x", first.String(), buf)
+	}
+	if val.PointerGet("/1").String() != "de" {
+		t.Errorf("append changed a neighbouring string")
+	}
+
+	copied, _ := CBORDecode(buf)
+	buf[2] = 'X'
+	if copied.PointerGet("/0").String() != "abc" {
+		t.Errorf("default decode references the input")
+	}
+}
+
+func benchmark_payload() []byte {
+	val := NewArray()
+	blob := bytes.Repeat([]byte{0xA5}, 4096)
+	for i := 0; i < 64; i++ {
+		val.ContainerInsertTail(NewBytestring(blob))
+		val.ContainerInsertTail(NewString(strings.Repeat("a", 256)))
+	}
+	return CBOREncode(val).Bytes()
+}
+
+func BenchmarkDecode(b *testing.B) {
+	buf := benchmark_payload()
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CBORDecode(buf)
+	}
+}
+
+func BenchmarkDecodeZeroCopy(b *testing.B) {
+	buf := benchmark_payload()
+	opts := DecodeOptions{ZeroCopy: true}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		opts.Decode(buf)
+	}
+}
+
+func number_payload() []byte {
+	val := NewArray()
+	for i := 0; i < 100000; i++ {
+		val.ContainerInsertTail(NewInteger(int64(i - 50000)))
+	}
+	return CBOREncode(val).Bytes()
+}
+
+func BenchmarkDecodeNumbers(b *testing.B) {
+	buf := number_payload()
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CBORDecode(buf)
+	}
+}
+
+func BenchmarkBuildNumbers(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		val := NewArray()
+		for j := 0; j < 100000; j++ {
+			val.ContainerInsertTail(NewInteger(int64(j)))
+		}
+	}
+}
+
+func BenchmarkContainerRemoveHead(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		val := NewArray()
+		for j := 0; j < 10000; j++ {
+			val.ContainerInsertTail(NewInteger(int64(j)))
+		}
+		b.StartTimer()
+		for !val.ContainerEmpty() {
+			val.ContainerRemove(val.ContainerFirst())
+		}
+	}
+}
@@ -1,250 +1,198 @@
 package cbor
 
+import "io"
 import "math"
+import "sort"
 import "bytes"
 import "encoding/binary"
 
-func write_word(buf *bytes.Buffer, w uint16) {
+// cbor_writer is satisfied by both *bytes.Buffer and *bufio.Writer.
+type cbor_writer interface {
+	io.Writer
+	io.ByteWriter
+}
+
+func write_word(buf cbor_writer, w uint16) {
 	flat := make([]byte, 2)
 	binary.BigEndian.PutUint16(flat, w)
 	buf.Write(flat)
 }
 
-func write_dword(buf *bytes.Buffer, dw uint32) {
+func write_dword(buf cbor_writer, dw uint32) {
 	flat := make([]byte, 4)
 	binary.BigEndian.PutUint32(flat, dw)
 	buf.Write(flat)
 }
 
-func write_qword(buf *bytes.Buffer, qw uint64) {
+func write_qword(buf cbor_writer, qw uint64) {
 	flat := make([]byte, 8)
 	binary.BigEndian.PutUint64(flat, qw)
 	buf.Write(flat)
 }
 
-func cbor_dump(val *CborValue, dst *bytes.Buffer) {
+// write_head writes the initial byte of a data item with the shortest
+// encoding of its argument.
+func write_head(dst cbor_writer, ctype int, argument uint64) {
+	var initial uint8 = uint8(ctype) << 5
+	if argument < 24 {
+		dst.WriteByte(initial | uint8(argument))
+	} else if argument <= 0xFF {
+		dst.WriteByte(initial | 24)
+		dst.WriteByte(uint8(argument))
+	} else if argument <= 0xFFFF {
+		dst.WriteByte(initial | 25)
+		write_word(dst, uint16(argument))
+	} else if argument <= 0xFFFFFFFF {
+		dst.WriteByte(initial | 26)
+		write_dword(dst, uint32(argument))
+	} else {
+		dst.WriteByte(initial | 27)
+		write_qword(dst, argument)
+	}
+}
+
+// float64_to_float16 converts real to half precision when that loses
+// nothing, NaN payloads included.
+func float64_to_float16(real float64) (uint16, bool) {
+	u64 := math.Float64bits(real)
+	sign := uint16(u64 >> 48) & 0x8000
+	exp := int(u64 >> 52) & 0x7FF
+	frac := u64 & 0xFFFFFFFFFFFFF
+	if exp == 0x7FF {
+		if frac & (1 << 42 - 1) != 0 {
+			return 0, false
+		}
+		return sign | 0x7C00 | uint16(frac >> 42), true
+	} else if exp == 0 && frac == 0 {
+		return sign, true
+	}
+
+	exp -= 1023
+	if exp >= -14 && exp <= 15 {
+		if frac & (1 << 42 - 1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(exp + 15) << 10 | uint16(frac >> 42), true
+	} else if exp >= -24 && exp < -14 {
+		// subnormal, the implicit leading bit becomes part of the fraction
+		frac |= 1 << 52
+		shift := uint(42 - 14 - exp)
+		if frac & (1 << shift - 1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(frac >> shift), true
+	}
+	return 0, false
+}
+
+func float64_to_float32(real float64) (uint32, bool) {
+	u64 := math.Float64bits(real)
+	if math.IsNaN(real) {
+		frac := u64 & 0xFFFFFFFFFFFFF
+		if frac & (1 << 29 - 1) != 0 {
+			return 0, false
+		}
+		return uint32(u64 >> 32) & 0x80000000 | 0x7F800000 | uint32(frac >> 29), true
+	}
+	f32 := float32(real)
+	if float64(f32) != real {
+		return 0, false
+	}
+	return math.Float32bits(f32), true
+}
+
+// write_float writes real in the shortest width that preserves its value,
+// which is the preferred serialization of RFC 8949 §4.1.
+func write_float(dst cbor_writer, real float64, opts *EncodeOptions) {
+	if math.IsNaN(real) && opts.Mode != CBOR_ENCODE_DEFAULT {
+		real = math.Float64frombits(0x7FF8000000000000)
+	}
+	if u16, ok := float64_to_float16(real); ok {
+		dst.WriteByte(uint8(CBOR_TYPE_SIMPLE << 5 | 25))
+		write_word(dst, u16)
+	} else if u32, ok := float64_to_float32(real); ok {
+		dst.WriteByte(uint8(CBOR_TYPE_SIMPLE << 5 | 26))
+		write_dword(dst, u32)
+	} else {
+		dst.WriteByte(uint8(CBOR_TYPE_SIMPLE << 5 | 27))
+		write_qword(dst, math.Float64bits(real))
+	}
+}
+
+type encoded_pair struct {
+	key  []byte
+	pair *CborValue
+}
+
+// dump_sorted_map writes the pairs of a map ordered by the encoding of their
+// keys, as required by the deterministic encoding modes.
+func dump_sorted_map(val *CborValue, dst cbor_writer, opts *EncodeOptions) {
+	pairs := make([]encoded_pair, 0, val.ContainerSize())
+	for ele := val.ContainerFirst(); ele != nil; ele = val.ContainerNext(ele) {
+		key := new(bytes.Buffer)
+		cbor_dump(ele.PairKey(), key, opts)
+		pairs = append(pairs, encoded_pair{key.Bytes(), ele})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return opts.key_less(pairs[i].key, pairs[j].key)
+	})
+	for _, p := range pairs {
+		dst.Write(p.key)
+		cbor_dump(p.pair.PairValue(), dst, opts)
+	}
+}
+
+func cbor_dump(val *CborValue, dst cbor_writer, opts *EncodeOptions) {
 	if val == nil || dst == nil {
 		return
 	}
-	var ctype uint8 = uint8(val.ctype)
-	ctype <<= 5
 	if val.ctype == CBOR_TYPE_UINT || val.ctype == CBOR_TYPE_NEGINT {
-		if val.integer < 24 {
-			ctype |= uint8(val.integer)
-			dst.WriteByte(ctype)
-		} else if val.integer <= 0xFF {
-			ctype |= 24
-			dst.WriteByte(ctype)
-			dst.WriteByte(uint8(val.integer))
-		} else if val.integer <= 0xFFFF {
-			ctype |= 25
-			dst.WriteByte(ctype)
-			write_word(dst, uint16(val.integer))
-		} else if val.integer <= 0xFFFFFFFF {
-			ctype |= 26
-			dst.WriteByte(ctype)
-			write_dword(dst, uint32(val.integer))
-		} else {
-			ctype |= 27
-			dst.WriteByte(ctype)
-			write_qword(dst, val.integer)
-		}
+		write_head(dst, val.ctype, val.num)
 	} else if val.ctype == CBOR_TYPE_BYTESTRING || val.ctype == CBOR_TYPE_STRING {
-		len := val.StringSize()
-		if len < 24 {
-			ctype |= uint8(len)
-			dst.WriteByte(ctype)
-			dst.Write(val.blob.Bytes())
-		} else if len <= 0xFF {
-			ctype |= 24
-			dst.WriteByte(ctype)
-			dst.WriteByte(uint8(len))
-			dst.Write(val.blob.Bytes())
-		} else if len <= 0xFFFF {
-			ctype |= 25
-			dst.WriteByte(ctype)
-			write_word(dst, uint16(len))
-			dst.Write(val.blob.Bytes())
-		} else if len <= 0xFFFFFFFF {
-			ctype |= 26
-			dst.WriteByte(ctype)
-			write_dword(dst, uint32(len))
-			dst.Write(val.blob.Bytes())
-		} else {
-			ctype |= 27
-			dst.WriteByte(ctype)
-			write_qword(dst, uint64(len))
-			dst.Write(val.blob.Bytes())
+		write_head(dst, val.ctype, uint64(val.StringSize()))
+		dst.Write(val.blob)
+	} else if val.ctype == CBOR__TYPE_RAW {
+		// spliced back unchanged, the deterministic modes re-encode it
+		if opts.Mode == CBOR_ENCODE_DEFAULT {
+			dst.Write(val.blob)
+		} else if item, err := CBORDecode(val.blob); err == nil {
+			cbor_dump(item, dst, opts)
 		}
 	} else if val.ctype == CBOR__TYPE_PAIR {
-		cbor_dump(val.key, dst)
-		cbor_dump(val.value, dst)
+		cbor_dump(val.PairKey(), dst, opts)
+		cbor_dump(val.PairValue(), dst, opts)
 	} else if val.ctype == CBOR_TYPE_ARRAY || val.ctype == CBOR_TYPE_MAP {
-		count := val.ContainerSize()
-		if count < 24 {
-			ctype |= uint8(count)
-			dst.WriteByte(ctype)
-		} else if count <= 0xFF {
-			ctype |= 24
-			dst.WriteByte(ctype)
-			dst.WriteByte(uint8(count))
-		} else if count <= 0xFFFF {
-			ctype |= 25
-			dst.WriteByte(ctype)
-			write_word(dst, uint16(count))
-		} else if count <= 0xFFFFFFFF {
-			ctype |= 26
-			dst.WriteByte(ctype)
-			write_dword(dst, uint32(count))
-		} else {
-			ctype |= 27
-			dst.WriteByte(ctype)
-			write_qword(dst, uint64(count))
+		write_head(dst, val.ctype, uint64(val.ContainerSize()))
+		if val.ctype == CBOR_TYPE_MAP && opts.Mode != CBOR_ENCODE_DEFAULT {
+			dump_sorted_map(val, dst, opts)
+			return
 		}
 		for ele := val.ContainerFirst(); ele != nil; ele = val.ContainerNext(ele) {
-			cbor_dump(ele, dst)
+			cbor_dump(ele, dst, opts)
 		}
 	} else if val.ctype == CBOR_TYPE_TAG {
-		if val.tag_item < 24 {
-			ctype |= uint8(val.tag_item)
-			dst.WriteByte(ctype)
-		} else if val.tag_item <= 0xFF {
-			ctype |= 24
-			dst.WriteByte(ctype)
-			dst.WriteByte(uint8(val.tag_item))
-		} else if val.tag_item <= 0xFFFF {
-			ctype |= 25
-			dst.WriteByte(ctype)
-			write_word(dst, uint16(val.tag_item))
-		} else if val.tag_item <= 0xFFFFFFFF {
-			ctype |= 26
-			dst.WriteByte(ctype)
-			write_dword(dst, uint32(val.tag_item))
-		} else {
-			ctype |= 27
-			dst.WriteByte(ctype)
-			write_qword(dst, uint64(val.tag_item))
+		write_head(dst, val.ctype, val.num)
+		if val.TagContent() == nil {
+			// a tag needs content to be well-formed
+			dst.WriteByte(uint8(CBOR_TYPE_SIMPLE << 5 | CBOR_SIMPLE_NULL))
+			return
 		}
-		cbor_dump(val.tag_content, dst)
+		cbor_dump(val.TagContent(), dst, opts)
 	} else if val.ctype == CBOR_TYPE_SIMPLE {
-		if val.ctrl == CBOR_SIMPLE_FALSE {
-			ctype |= 20
-			dst.WriteByte(ctype)
-		} else if val.ctrl == CBOR_SIMPLE_TRUE {
-			ctype |= 21
-			dst.WriteByte(ctype)
-		} else if val.ctrl == CBOR_SIMPLE_NULL {
-			ctype |= 22
-			dst.WriteByte(ctype)
-		} else if val.ctrl == CBOR_SIMPLE_UNDEF {
-			ctype |= 23
-			dst.WriteByte(ctype)
-		} else if val.ctrl == CBOR_SIMPLE_REAL {
-			u64 := math.Float64bits(val.real)
-			exp := int(u64 >> 52) & 0x7FF
-			sign := int(u64 >> 63)
-			frac := u64 & 0xFFFFFFFFFFFFF
-			var frac_bitcnt int
-			if frac > 0 {
-				for frac_bitcnt = 0; frac_bitcnt < 52; frac_bitcnt++ {
-					if frac & 1 > 0 {
-						break
-					}
-					frac >>= 1
-				}
-			} else {
-				frac_bitcnt = 52
-			}
-
-			frac_bitcnt = 52 - frac_bitcnt
-			frac = u64 & 0xFFFFFFFFFFFFF
-			if exp == 0 || exp == 0x7FF {
-				if frac_bitcnt <= 10 {
-					ctype |= 25
-					dst.WriteByte(ctype)
-
-					u16 := uint16(frac >> (52 - 10))
-					if sign == 1 {
-						u16 |= 1 << 15
-					}
-					if exp > 0 {
-						u16 |= 0x1F << 10
-					}
-					write_word(dst, u16)
-				} else if frac_bitcnt <= 23 {
-					ctype |= 26
-					dst.WriteByte(ctype)
-
-					u32 := uint32(frac >> (52 - 23))
-					if sign == 1 {
-						u32 |= 1 << 31
-					}
-					if exp > 0 {
-						u32 |= 0x3F << 23
-					}
-					write_dword(dst, u32)
-				} else {
-					ctype |= 27
-					dst.WriteByte(ctype)
-
-					u64 = frac
-					if sign == 1 {
-						u64 |= 1 << 63
-					}
-					if exp > 0 {
-						u64 |= 0x7FF << 52
-					}
-					write_qword(dst, u64)
-				}
-			} else {
-				exp -= 1023
-				if exp >= -14 && exp <= 15 && frac_bitcnt <= 10 {
-					ctype |= 25
-					dst.WriteByte(ctype)
-
-					u16 := uint16(frac >> (52 - 10))
-					if sign == 1 {
-						u16 |= 1 << 15
-					}
-					u16 |= uint16(exp + 15) << 10
-					write_word(dst, u16)
-				} else if exp >= -126 && exp <= 127 && frac_bitcnt <= 23 {
-					ctype |= 26
-					dst.WriteByte(ctype)
-
-					u32 := uint32(frac >> (52 - 23))
-					if sign == 1 {
-						u32 |= 1 << 31
-					}
-					u32 |= uint32(exp + 127) << 23
-					write_dword(dst, u32)
-				} else {
-					ctype |= 27
-					dst.WriteByte(ctype)
-
-					u64 = frac
-					if sign == 1 {
-						u64 |= 1 << 63
-					}
-					u64 |= uint64(exp + 1023) << 52
-					write_qword(dst, u64)
-				}
-			}
+		if val.ctrl == CBOR_SIMPLE_REAL {
+			write_float(dst, val.Float(), opts)
+		} else if val.ctrl < 24 {
+			dst.WriteByte(uint8(CBOR_TYPE_SIMPLE << 5 | val.ctrl))
+		} else if val.ctrl < 32 {
+			// simple values 24 to 31 have no well-formed encoding
+			dst.WriteByte(uint8(CBOR_TYPE_SIMPLE << 5 | CBOR_SIMPLE_UNDEF))
 		} else {
-			if val.ctrl < 24 {
-				ctype |= uint8(val.ctrl)
-				dst.WriteByte(ctype)
-			} else {
-				ctype |= 24
-				dst.WriteByte(ctype)
-				dst.WriteByte(uint8(val.ctrl))
-			}
+			dst.WriteByte(uint8(CBOR_TYPE_SIMPLE << 5 | 24))
+			dst.WriteByte(uint8(val.ctrl))
 		}
 	}
 }
 
 func CBOREncode(val *CborValue) *bytes.Buffer {
-	var buf = new(bytes.Buffer)
-	cbor_dump(val, buf)
-	return buf
+	return EncodeOptions{}.Encode(val)
 }
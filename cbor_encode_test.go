@@ -0,0 +1,117 @@
+package cbor
+
+import "math"
+import "bytes"
+import "testing"
+
+func TestEncodePreferred(t *testing.T) {
+	for idx, item := range content {
+		if idx >= 34 && idx < 40 || idx > 70 {
+			// non-preferred floats and indefinite lengths
+			continue
+		}
+		val, _ := CBORDecode([]byte(item))
+		for _, mode := range []EncodeMode{CBOR_ENCODE_DEFAULT, CBOR_ENCODE_CORE_DETERMINISTIC} {
+			buf := EncodeOptions{Mode: mode}.Encode(val)
+			if !bytes.Equal(buf.Bytes(), []byte(item)) {
+				t.Errorf("%d. not equal: %#v, %#v", idx, []byte(item), buf.Bytes())
+			}
+		}
+	}
+
+	floats := map[float64]string{
+		5.960464477539063e-08:       "\xf9\x00\x01",
+		6.103515625e-05:             "\xf9\x04\x00",
+		math.SmallestNonzeroFloat32: "\xfa\x00\x00\x00\x01",
+		1e-300:                      "\xfb\x01\xa5\x6e\x1f\xc2\xf8\xf3\x59",
+	}
+	for real, item := range floats {
+		buf := CBOREncode(NewFloat(real))
+		if !bytes.Equal(buf.Bytes(), []byte(item)) {
+			t.Errorf("%v. not equal: %#v, %#v", real, []byte(item), buf.Bytes())
+		}
+		val, _ := CBORDecode([]byte(item))
+		if float32(val.Float()) != float32(real) {
+			t.Errorf("%v. decode float fail: %v", real, val.Float())
+		}
+	}
+
+	val, _ := CBORDecode([]byte("\xfa\x7f\xc0\x00\x01"))
+	buf := EncodeOptions{Mode: CBOR_ENCODE_CORE_DETERMINISTIC}.Encode(val)
+	if !bytes.Equal(buf.Bytes(), []byte("\xf9\x7e\x00")) {
+		t.Errorf("NaN not canonical: %#v", buf.Bytes())
+	}
+}
+
+func TestEncodeSortedMap(t *testing.T) {
+	val := NewMap()
+	keys := []*CborValue{NewBoolean(false), New([]interface{}{-1}), New([]interface{}{100}), New("aa"), New("z"), New(-1), New(100), New(10)}
+	for i, key := range keys {
+		val.ContainerInsertTail(NewPair(key, New(i)))
+	}
+
+	sorted := map[EncodeMode][]int{
+		CBOR_ENCODE_DEFAULT:            {0, 1, 2, 3, 4, 5, 6, 7},
+		CBOR_ENCODE_CORE_DETERMINISTIC: {7, 6, 5, 4, 3, 2, 1, 0},
+		CBOR_ENCODE_LENGTH_FIRST:       {7, 5, 0, 6, 4, 1, 3, 2},
+		CBOR_ENCODE_CTAP2:              {7, 6, 5, 4, 3, 1, 2, 0},
+	}
+	for mode, order := range sorted {
+		decoded, err := CBORDecode(EncodeOptions{Mode: mode}.Encode(val).Bytes())
+		if err != nil {
+			t.Errorf("mode %d: decode fail: %v", mode, err)
+			continue
+		}
+		i := 0
+		for ele := decoded.ContainerFirst(); ele != nil; ele = decoded.ContainerNext(ele) {
+			if ele.PairValue().Integer() != int64(order[i]) {
+				t.Errorf("mode %d: pair %d out of order", mode, i)
+			}
+			i++
+		}
+	}
+}
+
+func TestEncodeRaw(t *testing.T) {
+	if NewRaw([]byte("\x82\x01")) != nil || NewRaw([]byte("\x01\x02")) != nil {
+		t.Errorf("expected nil for malformed raw encoding")
+	}
+	raw := NewRaw([]byte("\xa1\x61a\x19\x00\x01"))
+	if !raw.IsRaw() || !bytes.Equal(raw.Raw(), []byte("\xa1\x61a\x19\x00\x01")) {
+		t.Fatalf("raw node got %x", raw.Raw())
+	}
+	val := NewArray()
+	val.ContainerInsertTail(NewInteger(1))
+	val.ContainerInsertTail(raw)
+	if got := CBOREncode(val).Bytes(); !bytes.Equal(got, []byte("\x82\x01\xa1\x61a\x19\x00\x01")) {
+		t.Errorf("encode got %x", got)
+	}
+	if got := CBOREncode(val.Duplicate()).Bytes(); !bytes.Equal(got, []byte("\x82\x01\xa1\x61a\x19\x00\x01")) {
+		t.Errorf("encode duplicate got %x", got)
+	}
+	if got := JSONEncode(val).String(); got != `[1, {"a": 1}]` {
+		t.Errorf("json got %s", got)
+	}
+}
+
+func TestEncodeEmptyTag(t *testing.T) {
+	got := CBOREncode(NewTag()).Bytes()
+	if !bytes.Equal(got, []byte("\xc0\xf6")) || Valid(got) != nil {
+		t.Errorf("encode tag without content got %x", got)
+	}
+	if got := JSONEncode(NewTag()).String(); got != "null" {
+		t.Errorf("json tag without content got %s", got)
+	}
+}
+
+func TestEncodeReservedSimple(t *testing.T) {
+	got := CBOREncode(NewExt()).Bytes()
+	if !bytes.Equal(got, []byte("\xf7")) || Valid(got) != nil {
+		t.Errorf("encode reserved simple value got %x", got)
+	}
+	val := NewUndef()
+	val.ctrl = 31
+	if got := CBOREncode(val).Bytes(); !bytes.Equal(got, []byte("\xf7")) {
+		t.Errorf("encode simple value 31 got %x", got)
+	}
+}
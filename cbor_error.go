@@ -0,0 +1,82 @@
+package cbor
+
+import "fmt"
+
+type ErrorKind int
+
+const (
+	CBOR_ERR_TRUNCATED               ErrorKind = 1
+	CBOR_ERR_INVALID_ADDITIONAL_INFO ErrorKind = 2
+	CBOR_ERR_UNEXPECTED_BREAK        ErrorKind = 3
+	CBOR_ERR_INVALID_UTF8            ErrorKind = 4
+	CBOR_ERR_INVALID_CHUNK           ErrorKind = 5
+	CBOR_ERR_EXTRANEOUS_DATA         ErrorKind = 6
+	CBOR_ERR_NOT_DETERMINISTIC       ErrorKind = 7
+	CBOR_ERR_DUPLICATE_KEY           ErrorKind = 8
+)
+
+func (kind ErrorKind) String() string {
+	switch kind {
+	case CBOR_ERR_TRUNCATED:
+		return "truncated"
+	case CBOR_ERR_INVALID_ADDITIONAL_INFO:
+		return "invalid additional information"
+	case CBOR_ERR_UNEXPECTED_BREAK:
+		return "unexpected break"
+	case CBOR_ERR_INVALID_UTF8:
+		return "invalid utf-8"
+	case CBOR_ERR_INVALID_CHUNK:
+		return "invalid indefinite-length chunk"
+	case CBOR_ERR_EXTRANEOUS_DATA:
+		return "extraneous data"
+	case CBOR_ERR_NOT_DETERMINISTIC:
+		return "non-deterministic encoding"
+	case CBOR_ERR_DUPLICATE_KEY:
+		return "duplicate map key"
+	}
+	return fmt.Sprintf("error kind %d", int(kind))
+}
+
+// SyntaxError describes malformed CBOR input. Offset is the position of the
+// initial byte of the offending data item, MajorType is -1 when the input ends
+// before that byte.
+type SyntaxError struct {
+	Offset    int
+	MajorType int
+	Kind      ErrorKind
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("cbor: %s in major type %d at offset %d", e.Kind, e.MajorType, e.Offset)
+}
+
+func syntax_error(offset int, ctype int, kind ErrorKind) error {
+	return &SyntaxError{Offset: offset, MajorType: ctype, Kind: kind}
+}
+
+// LimitError reports input rejected by one of the DecodeOptions limits,
+// Limit names the option that was exceeded.
+type LimitError struct {
+	Offset int
+	Limit  string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("cbor: %s exceeded at offset %d", e.Limit, e.Offset)
+}
+
+// TagError reports a tag whose content the conversion registered for its
+// number in a TagSet rejected. Offset is the position of the tag.
+type TagError struct {
+	Offset int
+	Number uint64
+	Err    error
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("cbor: tag %d at offset %d: %v", e.Number, e.Offset, e.Err)
+}
+
+func (e *TagError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,40 @@
+package cbor
+
+import "io"
+import "bytes"
+import "testing"
+
+func FuzzCBORDecode(f *testing.F) {
+	for _, item := range content {
+		f.Add([]byte(item))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		val, err := CBORDecode(data)
+		if (Valid(data) == nil) != (err == nil) {
+			t.Fatalf("valid disagrees with decode on %#v: %v", data, err)
+		}
+		if err != nil {
+			if val != nil {
+				t.Fatalf("decode returned both value and error: %v", err)
+			}
+			return
+		}
+		tz := NewTokenizer(data)
+		for {
+			if _, err := tz.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("tokenize %#v: %v", data, err)
+			}
+		}
+
+		encoded := CBOREncode(val).Bytes()
+		again, err := CBORDecode(encoded)
+		if err != nil {
+			t.Fatalf("decode re-encoded %#v: %v", encoded, err)
+		}
+		if !bytes.Equal(CBOREncode(again).Bytes(), encoded) {
+			t.Fatalf("round trip not stable: %#v", data)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package cbor
+
+import "bytes"
+import "strconv"
+
+// maps with fewer entries are scanned, larger ones keep a key index that is
+// built and updated as the map changes, so lookups never write to it
+const map_index_min = 16
+
+// map_key returns the string key is indexed under. Only text, byte string and
+// integer keys are indexed, other keys are always found by a scan.
+func map_key(key *CborValue) (string, bool) {
+	if key == nil {
+		return "", false
+	}
+	switch key.ctype {
+	case CBOR_TYPE_STRING, CBOR_TYPE_BYTESTRING:
+		return string(rune('0' + key.ctype)) + string(key.blob), true
+	case CBOR_TYPE_UINT, CBOR_TYPE_NEGINT:
+		return string(rune('0' + key.ctype)) + strconv.FormatUint(key.num, 10), true
+	}
+	return "", false
+}
+
+// key_equal reports whether two map keys are the same data item.
+func key_equal(a *CborValue, b *CborValue) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if ka, ok := map_key(a); ok {
+		kb, ok := map_key(b)
+		return ok && ka == kb
+	}
+	if _, ok := map_key(b); ok {
+		return false
+	}
+	return bytes.Equal(CBOREncode(a).Bytes(), CBOREncode(b).Bytes())
+}
+
+// map_index returns the key index of a map, or nil while the map is small.
+// Each key maps to the only pair that has it, or to nil when several pairs
+// share it and the map has to be scanned.
+func (container *CborValue) map_index() map[string]*CborValue {
+	return container.index
+}
+
+// index_build indexes a map once it has grown large enough.
+func (container *CborValue) index_build() {
+	if container.index == nil && container.IsMap() && len(container.items) >= map_index_min {
+		container.index = make(map[string]*CborValue, len(container.items))
+		for _, pair := range container.items {
+			container.index_add(pair)
+		}
+	}
+}
+
+func (container *CborValue) index_add(pair *CborValue) {
+	if k, ok := map_key(pair.PairKey()); ok {
+		if _, found := container.index[k]; found {
+			container.index[k] = nil
+		} else {
+			container.index[k] = pair
+		}
+	}
+}
+
+// index_remove drops a pair leaving the map. A key several pairs share stays
+// marked for a scan.
+func (container *CborValue) index_remove(pair *CborValue) {
+	if k, ok := map_key(pair.PairKey()); ok && container.index[k] == pair {
+		delete(container.index, k)
+	}
+}
+
+// key_changed reindexes the map s is a key of, after s changed.
+func (s *CborValue) key_changed() {
+	if pair := s.parent; pair != nil && s.pos == 0 && pair.ctype == CBOR__TYPE_PAIR && pair.parent != nil {
+		pair.parent.index = nil
+		pair.parent.index_build()
+	}
+}
+
+// map_find returns the first pair of a map whose key is key.
+func (container *CborValue) map_find(key *CborValue) *CborValue {
+	if !container.IsMap() || key == nil {
+		return nil
+	}
+	if k, ok := map_key(key); ok {
+		if index := container.map_index(); index != nil {
+			if pair, found := index[k]; !found || pair != nil {
+				return pair
+			}
+		}
+	}
+	for _, pair := range container.items {
+		if key_equal(pair.PairKey(), key) {
+			return pair
+		}
+	}
+	return nil
+}
+
+// map_lookup_key converts a key given to MapGet, MapSet or MapDelete.
+func map_lookup_key(key interface{}) *CborValue {
+	if val, ok := key.(*CborValue); ok {
+		return val
+	}
+	return New(key)
+}
+
+// MapGet returns the value of the first pair of a map whose key is key, or
+// nil. Large maps are looked up through an index kept in insertion order.
+func (container *CborValue) MapGet(key interface{}) *CborValue {
+	return container.map_find(map_lookup_key(key)).PairValue()
+}
+
+// MapSet replaces the value of the first pair whose key is key, or appends a
+// new pair, and returns the value stored.
+func (container *CborValue) MapSet(key interface{}, value interface{}) *CborValue {
+	if !container.IsMap() {
+		return nil
+	}
+	k := map_lookup_key(key)
+	val := New(value)
+	if k == nil || val == nil {
+		return nil
+	}
+	if pair := container.map_find(k); pair != nil {
+		pair.SetValue(val)
+	} else {
+		if _, ok := key.(*CborValue); ok {
+			k = k.Duplicate()
+		}
+		container.ContainerInsertTail(NewPair(k, val))
+	}
+	return val
+}
+
+// MapDelete removes the first pair whose key is key and returns its value.
+func (container *CborValue) MapDelete(key interface{}) *CborValue {
+	pair := container.map_find(map_lookup_key(key))
+	if pair == nil {
+		return nil
+	}
+	container.ContainerRemove(pair)
+	val := pair.items[1]
+	if val != nil {
+		val.parent = nil
+		val.pos = 0
+	}
+	pair.items[1] = nil
+	return val
+}
@@ -0,0 +1,115 @@
+package cbor
+
+import "bytes"
+import "sync"
+import "strconv"
+import "testing"
+
+func TestMapIndex(t *testing.T) {
+	for _, size := range []int{4, 100} {
+		v := NewMap()
+		for i := 0; i < size; i++ {
+			v.MapSet("k" + strconv.Itoa(i), i)
+		}
+		v.MapSet(-7, "negative")
+		v.MapSet([]byte("k1"), "bytes")
+		v.MapSet(1.5, "float")
+
+		for i := 0; i < size; i++ {
+			if ele := v.MapGet("k" + strconv.Itoa(i)); ele == nil || ele.Integer() != int64(i) {
+				t.Errorf("%d. map get k%d fail", size, i)
+			}
+		}
+		if v.MapGet(-7).String() != "negative" || v.MapGet(NewInteger(-7)).String() != "negative" || v.MapGet(7) != nil {
+			t.Errorf("%d. map get integer key fail", size)
+		}
+		if v.MapGet([]byte("k1")).String() != "bytes" || v.MapGet("k1").Integer() != 1 {
+			t.Errorf("%d. map get keeps text and byte keys apart fail", size)
+		}
+		if v.MapGet(1.5).String() != "float" || v.MapGet("missing") != nil {
+			t.Errorf("%d. map get unindexed key fail", size)
+		}
+		if v.PointerGet("/k2").Integer() != 2 || v.PointerGet("/-7").String() != "negative" {
+			t.Errorf("%d. pointer get through index fail", size)
+		}
+
+		// replacing keeps the position, deleting keeps the order of the rest
+		if val := v.MapSet("k0", "zero"); val == nil || v.ContainerFirst().PairValue() != val {
+			t.Errorf("%d. map set existing key fail", size)
+		}
+		if val := v.MapDelete("k1"); val == nil || val.Integer() != 1 || val.parent != nil {
+			t.Errorf("%d. map delete fail", size)
+		}
+		if v.MapGet("k1") != nil || v.MapDelete("k1") != nil || v.ContainerAt(1).PairKey().String() != "k2" {
+			t.Errorf("%d. map after delete fail", size)
+		}
+		if v.ContainerSize() != size + 2 {
+			t.Errorf("%d. map size %d after delete", size, v.ContainerSize())
+		}
+
+		// the first pair with a key wins, removing it uncovers the next one
+		v.ContainerInsertHead(NewPair(NewString("k3"), NewString("first")))
+		if v.MapGet("k3").String() != "first" || v.PointerGet("/k3").String() != "first" {
+			t.Errorf("%d. duplicate key inserted at head fail", size)
+		}
+		v.MapDelete("k3")
+		if v.MapGet("k3").Integer() != 3 {
+			t.Errorf("%d. duplicate key after delete fail", size)
+		}
+
+		// a key changed in place is found under its new value
+		v.ContainerFirst().PairKey().BlobAppend("y")
+		if v.MapGet("k0y").String() != "zero" || v.MapGet("k0") != nil {
+			t.Errorf("%d. changed key fail", size)
+		}
+
+		dup := v.Duplicate()
+		if !bytes.Equal(CBOREncode(dup).Bytes(), CBOREncode(v).Bytes()) || dup.MapGet("k2").Integer() != 2 {
+			t.Errorf("%d. duplicate indexed map fail", size)
+		}
+	}
+
+	if NewArray().MapSet("a", 1) != nil || NewArray().MapGet("a") != nil || NewMap().MapDelete("a") != nil {
+		t.Errorf("map helpers on wrong type fail")
+	}
+}
+
+func TestMapIndexConcurrent(t *testing.T) {
+	v := NewMap()
+	for i := 0; i < 40; i++ {
+		v.MapSet(string(rune('a' + i)), i)
+	}
+	decoded, err := CBORDecode(CBOREncode(v).Bytes())
+	if err != nil || v.index == nil || decoded.index == nil {
+		t.Fatalf("map index not built on insert: %v", err)
+	}
+
+	// lookups only read the index, run with -race to check
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if v.PointerGet("/b").Integer() != 1 || decoded.MapGet("b").Integer() != 1 {
+					t.Errorf("concurrent lookup fail")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkMapGet(b *testing.B) {
+	v := NewMap()
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		v.ContainerInsertTail(NewPair(NewString(keys[i]), NewInteger(int64(i))))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.PointerGet("/" + keys[i % len(keys)])
+	}
+}
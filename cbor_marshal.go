@@ -0,0 +1,499 @@
+package cbor
+
+import "sort"
+import "errors"
+import "sync"
+import "encoding"
+import "strconv"
+import "time"
+import "reflect"
+import "strings"
+
+// Tag is how a tagged data item without a Go type of its own appears in an
+// interface{} value, Marshal turns it back into a tag.
+type Tag struct {
+	Number  uint64
+	Content interface{}
+}
+
+// RawMessage is the encoding of a single data item. Unmarshal stores the
+// original bytes of the item in it and Marshal writes them back unchanged,
+// which defers decoding or passes an item through untouched. The
+// deterministic encoding modes re-encode it like any other item.
+type RawMessage []byte
+
+func (m RawMessage) MarshalCBOR() ([]byte, error) {
+	if m == nil {
+		return []byte{0xF6}, nil
+	}
+	return m, nil
+}
+
+func (m *RawMessage) UnmarshalCBOR(data []byte) error {
+	if m == nil {
+		return errors.New("cbor.RawMessage: UnmarshalCBOR on nil pointer")
+	}
+	*m = append((*m)[0:0], data...)
+	return nil
+}
+
+// SimpleValue is an unassigned simple value in an interface{} value.
+type SimpleValue uint8
+
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "cbor: unsupported type: " + e.Type.String()
+}
+
+// UnsupportedValueError reports a value of a supported type that has no
+// well-formed encoding, such as the simple values 24 to 31.
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "cbor: unsupported value: " + e.Str
+}
+
+// Marshaler is implemented by types that encode themselves into a single
+// well-formed CBOR data item.
+type Marshaler interface {
+	MarshalCBOR() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that decode themselves from the
+// encoding of a single data item, which must be copied if it is retained.
+type Unmarshaler interface {
+	UnmarshalCBOR([]byte) error
+}
+
+// MarshalerError wraps an error returned by, or an invalid encoding produced
+// by, the MarshalCBOR, MarshalBinary or MarshalText method of Type.
+type MarshalerError struct {
+	Type reflect.Type
+	Err  error
+}
+
+func (e *MarshalerError) Error() string {
+	return "cbor: error calling marshal method for type " + e.Type.String() + ": " + e.Err.Error()
+}
+
+func (e *MarshalerError) Unwrap() error {
+	return e.Err
+}
+
+type field_info struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	tagged    bool
+	omitempty bool
+	keyasint  bool
+	key_int   int64
+}
+
+var field_cache sync.Map // map[reflect.Type][]field_info
+
+func parse_field_tag(tag string) (string, []string) {
+	split := strings.Split(tag, ",")
+	return split[0], split[1:]
+}
+
+// struct_fields lists the fields of a struct type as encoding/json would,
+// including fields promoted from embedded structs, in declaration order.
+func struct_fields(t reflect.Type) []field_info {
+	if fields, ok := field_cache.Load(t); ok {
+		return fields.([]field_info)
+	}
+
+	type pending struct {
+		typ   reflect.Type
+		index []int
+	}
+	var fields []field_info
+	current := []pending{}
+	next := []pending{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count := map[string]int{}
+		var level []field_info
+		for _, p := range current {
+			if visited[p.typ] {
+				continue
+			}
+			visited[p.typ] = true
+			for i := 0; i < p.typ.NumField(); i++ {
+				sf := p.typ.Field(i)
+				tag := sf.Tag.Get("cbor")
+				if tag == "-" {
+					continue
+				}
+				name, options := parse_field_tag(tag)
+				index := make([]int, len(p.index) + 1)
+				copy(index, p.index)
+				index[len(p.index)] = i
+
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr && ft.Name() == "" {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+					next = append(next, pending{typ: ft, index: index})
+					continue
+				}
+				if sf.PkgPath != "" {
+					continue
+				}
+				field := field_info{name: name, index: index, typ: sf.Type, tagged: name != ""}
+				if field.name == "" {
+					field.name = sf.Name
+				}
+				for _, opt := range options {
+					if opt == "omitempty" {
+						field.omitempty = true
+					} else if opt == "keyasint" {
+						i, err := strconv.ParseInt(field.name, 10, 64)
+						field.keyasint = err == nil
+						field.key_int = i
+					}
+				}
+				level = append(level, field)
+				count[field.name]++
+			}
+		}
+
+		// a name already taken at a shallower depth hides deeper ones, a
+		// name repeated at the same depth is dropped unless exactly one of
+		// the fields is tagged
+		taken := map[string]bool{}
+		for _, f := range fields {
+			taken[f.name] = true
+		}
+		for _, f := range level {
+			if taken[f.name] {
+				continue
+			}
+			if count[f.name] > 1 {
+				var winner *field_info = nil
+				tagged := 0
+				for i := range level {
+					if level[i].name == f.name && level[i].tagged {
+						winner = &level[i]
+						tagged++
+					}
+				}
+				taken[f.name] = true
+				if tagged == 1 {
+					fields = append(fields, *winner)
+				}
+				continue
+			}
+			fields = append(fields, f)
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	field_cache.Store(t, fields)
+	return fields
+}
+
+// struct_toarray reports whether a struct type asks to be encoded as an
+// array of its fields, which a blank field tagged `cbor:",toarray"` does.
+func struct_toarray(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Name == "_" {
+			_, options := parse_field_tag(sf.Tag.Get("cbor"))
+			for _, opt := range options {
+				if opt == "toarray" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// field_by_index walks index from v, returning an invalid value when a nil
+// embedded pointer is met and alloc is false.
+func field_by_index(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func is_empty_value(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// values nested deeper than this are taken for a cycle, which would
+// otherwise recurse until the stack overflows
+const marshal_depth_max = 10000
+
+var cbor_value_type = reflect.TypeOf((*CborValue)(nil))
+var tag_type = reflect.TypeOf(Tag{})
+var simple_type = reflect.TypeOf(SimpleValue(0))
+var marshaler_type = reflect.TypeOf((*Marshaler)(nil)).Elem()
+var binary_marshaler_type = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+var text_marshaler_type = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// marshal_method encodes v with the first of MarshalCBOR, MarshalBinary and
+// MarshalText it implements, reporting false when it implements none.
+func marshal_method(v reflect.Value) (*CborValue, bool, error) {
+	t := v.Type()
+	if v.Kind() == reflect.Interface || !v.CanInterface() {
+		return nil, false, nil
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && !t.Implements(marshaler_type) &&
+		!t.Implements(binary_marshaler_type) && !t.Implements(text_marshaler_type) {
+		v = v.Addr()
+	}
+	if !v.Type().Implements(marshaler_type) && !v.Type().Implements(binary_marshaler_type) &&
+		!v.Type().Implements(text_marshaler_type) {
+		return nil, false, nil
+	}
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return NewNull(), true, nil
+	}
+
+	switch m := v.Interface().(type) {
+	case Marshaler:
+		b, err := m.MarshalCBOR()
+		if err != nil {
+			return nil, true, &MarshalerError{t, err}
+		}
+		if err := Valid(b); err != nil {
+			return nil, true, &MarshalerError{t, err}
+		}
+		return NewRaw(b), true, nil
+	case encoding.BinaryMarshaler:
+		b, err := m.MarshalBinary()
+		if err != nil {
+			return nil, true, &MarshalerError{t, err}
+		}
+		return NewBytestring(b), true, nil
+	case encoding.TextMarshaler:
+		b, err := m.MarshalText()
+		if err != nil {
+			return nil, true, &MarshalerError{t, err}
+		}
+		return NewString(string(b)), true, nil
+	}
+	return nil, false, nil
+}
+
+func marshal_value(v reflect.Value, opts *EncodeOptions, depth int) (*CborValue, error) {
+	if !v.IsValid() {
+		return NewNull(), nil
+	}
+	if depth > marshal_depth_max {
+		return nil, &UnsupportedValueError{v, "nested deeper than " + strconv.Itoa(marshal_depth_max) + " levels, possibly a cycle"}
+	}
+	if entry := opts.Tags.encoder(v.Type()); entry != nil && v.CanInterface() {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return NewNull(), nil
+		}
+		content, err := entry.encode(v.Interface())
+		if err != nil {
+			return nil, &MarshalerError{v.Type(), err}
+		}
+		val, err := marshal_value(reflect.ValueOf(content), opts, depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return NewTagged(entry.number, val), nil
+	}
+	if v.Type() == time_type && v.CanInterface() {
+		return NewTimeFormat(v.Interface().(time.Time), opts.TimeFormat), nil
+	}
+	if val, ok := marshal_big(v); ok {
+		return val, nil
+	}
+	if val, ok, err := marshal_method(v); ok {
+		return val, err
+	}
+	if v.Type() == cbor_value_type {
+		if v.IsNil() {
+			return NewNull(), nil
+		}
+		return v.Interface().(*CborValue).Duplicate(), nil
+	} else if v.Type() == tag_type {
+		tag := v.Interface().(Tag)
+		content, err := marshal_value(reflect.ValueOf(tag.Content), opts, depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return NewTagged(tag.Number, content), nil
+	} else if v.Type() == simple_type {
+		if v.Uint() >= 24 && v.Uint() < 32 {
+			return nil, &UnsupportedValueError{v, "simple value " + strconv.FormatUint(v.Uint(), 10)}
+		}
+		val := NewUndef()
+		val.ctrl = int(v.Uint())
+		return val, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return NewBoolean(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInteger(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NewUint64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return NewFloat(v.Float()), nil
+	case reflect.String:
+		return NewString(v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return NewNull(), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return NewBytestring(v.Bytes()), nil
+		}
+		if opts.TypedArrays {
+			if val := marshal_typed(v, opts); val != nil {
+				return val, nil
+			}
+		}
+		return marshal_array(v, opts, depth)
+	case reflect.Array:
+		if opts.TypedArrays {
+			if val := marshal_typed(v, opts); val != nil {
+				return val, nil
+			}
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return NewBytestring(b), nil
+		}
+		return marshal_array(v, opts, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			return NewNull(), nil
+		}
+		val := NewMap()
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := marshal_value(iter.Key(), opts, depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			ele, err := marshal_value(iter.Value(), opts, depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			val.ContainerInsertTail(NewPair(key, ele))
+		}
+		return val, nil
+	case reflect.Struct:
+		return marshal_struct(v, opts, depth)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NewNull(), nil
+		}
+		return marshal_value(v.Elem(), opts, depth + 1)
+	}
+	return nil, &UnsupportedTypeError{v.Type()}
+}
+
+func marshal_array(v reflect.Value, opts *EncodeOptions, depth int) (*CborValue, error) {
+	val := NewArray()
+	for i := 0; i < v.Len(); i++ {
+		ele, err := marshal_value(v.Index(i), opts, depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		val.ContainerInsertTail(ele)
+	}
+	return val, nil
+}
+
+func marshal_struct(v reflect.Value, opts *EncodeOptions, depth int) (*CborValue, error) {
+	if struct_toarray(v.Type()) {
+		val := NewArray()
+		for _, f := range struct_fields(v.Type()) {
+			ele, err := marshal_value(field_by_index(v, f.index, false), opts, depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			val.ContainerInsertTail(ele)
+		}
+		return val, nil
+	}
+
+	val := NewMap()
+	for _, f := range struct_fields(v.Type()) {
+		fv := field_by_index(v, f.index, false)
+		if !fv.IsValid() || (f.omitempty && is_empty_value(fv)) {
+			continue
+		}
+		ele, err := marshal_value(fv, opts, depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key := NewString(f.name)
+		if f.keyasint {
+			key = NewInteger(f.key_int)
+		}
+		val.ContainerInsertTail(NewPair(key, ele))
+	}
+	return val, nil
+}
+
+func (opts EncodeOptions) Marshal(v interface{}) ([]byte, error) {
+	val, err := marshal_value(reflect.ValueOf(v), &opts, 0)
+	if err != nil {
+		return nil, err
+	}
+	return opts.Encode(val).Bytes(), nil
+}
+
+// Marshal returns the CBOR encoding of v. Structs encode as maps keyed by
+// field name, which the `cbor:"name,omitempty"` field tag controls like the
+// json tag does for encoding/json. Values implementing Marshaler,
+// encoding.BinaryMarshaler or encoding.TextMarshaler encode themselves, a
+// time.Time encodes in the date or time tag EncodeOptions.TimeFormat picks.
+// Cyclic data structures are not supported, Marshal returns an
+// UnsupportedValueError for them.
+func Marshal(v interface{}) ([]byte, error) {
+	return EncodeOptions{}.Marshal(v)
+}
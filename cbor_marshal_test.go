@@ -0,0 +1,356 @@
+package cbor
+
+import "bytes"
+import "errors"
+import "reflect"
+import "testing"
+
+type marshal_inner struct {
+	Depth int
+	Name  string `cbor:"inner_name"`
+}
+
+type marshal_outer struct {
+	marshal_inner
+	*MarshalPointer
+	Name     string
+	Count    uint16            `cbor:"count,omitempty"`
+	Ratio    float32           `cbor:"ratio"`
+	Blob     []byte            `cbor:"blob"`
+	Hash     [4]byte           `cbor:"hash"`
+	List     []int             `cbor:"list"`
+	Pair     [2]string         `cbor:"pair"`
+	Table    map[string]uint64 `cbor:"table"`
+	Keys     map[int]bool      `cbor:"keys"`
+	Next     *marshal_outer    `cbor:"next,omitempty"`
+	Any      interface{}       `cbor:"any"`
+	Raw      *CborValue        `cbor:"raw"`
+	Skipped  string            `cbor:"-"`
+	internal int
+}
+
+type MarshalPointer struct {
+	Pointer string
+}
+
+type marshal_keyasint struct {
+	Alg  int    `cbor:"1,keyasint"`
+	Kid  []byte `cbor:"-2,keyasint,omitempty"`
+	Name string `cbor:"name"`
+}
+
+type marshal_toarray struct {
+	_     struct{} `cbor:",toarray"`
+	Name  string
+	Count int
+}
+
+func TestMarshalKeyAsIntToArray(t *testing.T) {
+	data, err := Marshal(marshal_keyasint{Alg: -7, Kid: []byte{1}, Name: "key"})
+	if err != nil || !bytes.Equal(data, []byte("\xa3\x01\x26\x21\x41\x01\x64name\x63key")) {
+		t.Errorf("keyasint marshal got %x, %v", data, err)
+	}
+	var key marshal_keyasint
+	if err := Unmarshal(data, &key); err != nil || key.Alg != -7 || !bytes.Equal(key.Kid, []byte{1}) || key.Name != "key" {
+		t.Errorf("keyasint unmarshal got %#v, %v", key, err)
+	}
+
+	data, err = Marshal(marshal_toarray{Name: "a", Count: 2})
+	if err != nil || !bytes.Equal(data, []byte("\x82\x61a\x02")) {
+		t.Errorf("toarray marshal got %x, %v", data, err)
+	}
+	var arr marshal_toarray
+	if err := Unmarshal([]byte("\x83\x61b\x03\x04"), &arr); err != nil || arr.Name != "b" || arr.Count != 3 {
+		t.Errorf("toarray unmarshal got %#v, %v", arr, err)
+	}
+}
+
+type marshal_money struct {
+	units int64
+	cents uint8
+}
+
+func (m marshal_money) MarshalCBOR() ([]byte, error) {
+	return Marshal([]int64{m.units, int64(m.cents)})
+}
+
+func (m *marshal_money) UnmarshalCBOR(data []byte) error {
+	var parts [2]int64
+	if err := Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	m.units, m.cents = parts[0], uint8(parts[1])
+	return nil
+}
+
+type marshal_id uint32
+
+func (id marshal_id) MarshalText() ([]byte, error) {
+	return []byte("id-" + string(rune('a' + id))), nil
+}
+
+func (id *marshal_id) UnmarshalText(text []byte) error {
+	if len(text) != 4 || string(text[:3]) != "id-" {
+		return errors.New("bad id")
+	}
+	*id = marshal_id(text[3] - 'a')
+	return nil
+}
+
+type marshal_flags struct {
+	bits byte
+}
+
+func (f *marshal_flags) MarshalBinary() ([]byte, error) {
+	return []byte{f.bits}, nil
+}
+
+func (f *marshal_flags) UnmarshalBinary(data []byte) error {
+	f.bits = data[0]
+	return nil
+}
+
+type marshal_custom struct {
+	Price marshal_money  `cbor:"price"`
+	ID    marshal_id     `cbor:"id"`
+	Flags marshal_flags  `cbor:"flags"`
+	Owner *marshal_money `cbor:"owner"`
+}
+
+func TestMarshaler(t *testing.T) {
+	in := marshal_custom{Price: marshal_money{12, 34}, ID: 2, Flags: marshal_flags{5}}
+	data, err := Marshal(&in)
+	want := "\xa4\x65price\x82\x0c\x18\x22\x62id\x64id-c\x65flags\x41\x05\x65owner\xf6"
+	if err != nil || string(data) != want {
+		t.Fatalf("marshal got %x, %v", data, err)
+	}
+
+	var out marshal_custom
+	if err := Unmarshal(data, &out); err != nil || out != in {
+		t.Errorf("unmarshal got %#v, %v", out, err)
+	}
+	if err := Unmarshal([]byte("\xa1\x65owner\x82\x01\x02"), &out); err != nil || out.Owner == nil || *out.Owner != (marshal_money{1, 2}) {
+		t.Errorf("unmarshal pointer got %#v, %v", out.Owner, err)
+	}
+	if err := Unmarshal([]byte("\xa1\x62id\x64bad!"), &out); err == nil || err.Error() != "bad id" {
+		t.Errorf("expected method error, got %v", err)
+	}
+	var typeerr *UnmarshalTypeError
+	if err := Unmarshal([]byte("\xa1\x62id\x01"), &out); !errors.As(err, &typeerr) {
+		t.Errorf("expected type error, got %v", err)
+	}
+
+	var marshalerr *MarshalerError
+	if _, err := Marshal(marshal_bad{}); !errors.As(err, &marshalerr) {
+		t.Errorf("expected marshaler error, got %v", err)
+	}
+}
+
+type marshal_envelope struct {
+	Kind string     `cbor:"kind"`
+	Body RawMessage `cbor:"body"`
+}
+
+func TestRawMessage(t *testing.T) {
+	// the body uses a non-preferred integer encoding that must survive
+	data := []byte("\xa2\x64kind\x61a\x64body\x82\x18\x01\x9f\xff")
+	var env marshal_envelope
+	if err := Unmarshal(data, &env); err != nil || env.Kind != "a" || string(env.Body) != "\x82\x18\x01\x9f\xff" {
+		t.Fatalf("unmarshal got %#v, %v", env, err)
+	}
+	out, err := Marshal(env)
+	if err != nil || !bytes.Equal(out, data) {
+		t.Errorf("marshal got %x, %v", out, err)
+	}
+	out, err = EncodeOptions{Mode: CBOR_ENCODE_CORE_DETERMINISTIC}.Marshal(marshal_envelope{})
+	if err != nil || string(out) != "\xa2\x64body\xf6\x64kind\x60" {
+		t.Errorf("marshal nil raw got %x, %v", out, err)
+	}
+	if _, err := Marshal(RawMessage("\x01\x02")); err == nil {
+		t.Errorf("expected error for raw message with trailing data")
+	}
+
+	// the deterministic modes canonicalize what MarshalCBOR returns
+	raw := RawMessage("\xa2\x02\x00\x01\x19\x00\x01")
+	out, err = EncodeOptions{Mode: CBOR_ENCODE_CORE_DETERMINISTIC}.Marshal(raw)
+	if err != nil || string(out) != "\xa2\x01\x01\x02\x00" {
+		t.Errorf("deterministic marshal of raw got %x, %v", out, err)
+	}
+	if err := (DecodeOptions{Strict: true}).Valid(out); err != nil {
+		t.Errorf("strict valid of deterministic raw fail: %v", err)
+	}
+	if out, err = Marshal(raw); err != nil || !bytes.Equal(out, raw) {
+		t.Errorf("default marshal of raw got %x, %v", out, err)
+	}
+}
+
+type marshal_bad struct{}
+
+func (marshal_bad) MarshalCBOR() ([]byte, error) {
+	return []byte("\x82\x01"), nil
+}
+
+func TestMarshal(t *testing.T) {
+	in := marshal_outer{
+		marshal_inner:  marshal_inner{Depth: 3, Name: "inner"},
+		MarshalPointer: &MarshalPointer{Pointer: "pointer"},
+		Name:           "outer",
+		Ratio:          1.5,
+		Blob:           []byte{1, 2, 3},
+		Hash:           [4]byte{4, 5, 6, 7},
+		List:           []int{-1, 0, 1},
+		Pair:           [2]string{"a", "b"},
+		Table:          map[string]uint64{"max": 1<<64 - 1},
+		Keys:           map[int]bool{-10: true},
+		Next:           &marshal_outer{Name: "next"},
+		Any:            []interface{}{"x", uint64(1), int64(-2), nil},
+		Raw:            New(map[string]interface{}{"k": "v"}),
+		Skipped:        "skipped",
+		internal:       1,
+	}
+	buf, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("marshal fail: %v", err)
+	}
+
+	val, err := CBORDecode(buf)
+	if err != nil {
+		t.Fatalf("decode marshaled fail: %v", err)
+	}
+	if val.PointerGet("/Depth").Integer() != 3 || val.PointerGet("/inner_name").String() != "inner" || val.PointerGet("/Pointer").String() != "pointer" {
+		t.Log("embedded fields not promoted")
+		t.Fail()
+	}
+	if val.PointerGet("/count") != nil || val.PointerGet("/Skipped") != nil || val.PointerGet("/internal") != nil {
+		t.Log("omitted fields encoded")
+		t.Fail()
+	}
+	if !val.PointerGet("/blob").IsString() || !val.PointerGet("/hash").IsString() || !val.PointerGet("/next/list").IsNull() {
+		t.Log("bytes or nil slice encoded wrong")
+		t.Fail()
+	}
+
+	var out marshal_outer
+	if err = Unmarshal(buf, &out); err != nil {
+		t.Fatalf("unmarshal fail: %v", err)
+	}
+	in.Skipped = ""
+	in.internal = 0
+	if !bytes.Equal(CBOREncode(in.Raw).Bytes(), CBOREncode(out.Raw).Bytes()) {
+		t.Log("unmarshal *CborValue fail")
+		t.Fail()
+	}
+	if !out.Next.Raw.IsNull() {
+		t.Log("unmarshal null into *CborValue fail")
+		t.Fail()
+	}
+	in.Raw, out.Raw, out.Next.Raw = nil, nil, nil
+	in.Next.Keys, in.Next.Table = nil, nil
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("unmarshal not equal:\n%#v\n%#v", in, out)
+	}
+
+	if buf, err := Marshal(SimpleValue(32)); err != nil || !bytes.Equal(buf, []byte("\xf8\x20")) {
+		t.Errorf("marshal simple value got %x, %v", buf, err)
+	}
+	var unsupported *UnsupportedValueError
+	if _, err := Marshal(SimpleValue(24)); !errors.As(err, &unsupported) {
+		t.Errorf("expected unsupported value error, got %v", err)
+	}
+
+	// a cycle ends in an error instead of a stack overflow
+	type node struct {
+		Next *node
+	}
+	cycle := &node{}
+	cycle.Next = cycle
+	if _, err := Marshal(cycle); !errors.As(err, &unsupported) {
+		t.Errorf("expected unsupported value error for a cycle, got %v", err)
+	}
+	loop := map[string]interface{}{}
+	loop["self"] = loop
+	if _, err := Marshal(loop); !errors.As(err, &unsupported) {
+		t.Errorf("expected unsupported value error for a map cycle, got %v", err)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	var i8 int8
+	var u uint
+	var f float64
+	var s string
+	var b []byte
+	var any interface{}
+	var ints []int
+	var arr [2]int
+	var ptr *int
+
+	cases := []struct {
+		item   string
+		target interface{}
+		expect interface{}
+	}{
+		{"\x38\x63", &i8, int8(-100)},
+		{"\x19\x03\xe8", &u, uint(1000)},
+		{"\xf9\x3e\x00", &f, 1.5},
+		{"\x19\x03\xe8", &f, 1000.0},
+		{"\x7f\x65\x73\x74\x72\x65\x61\x64\x6d\x69\x6e\x67\xff", &s, "streaming"},
+		{"\x5f\x42\x01\x02\x43\x03\x04\x05\xff", &b, []byte{1, 2, 3, 4, 5}},
+		{"\x9f\x01\x02\x03\xff", &ints, []int{1, 2, 3}},
+		{"\x83\x01\x02\x03", &arr, [2]int{1, 2}},
+		{"\x01", &ptr, 1},
+		{"\xc1\x1a\x51\x4b\x67\xb0", &u, uint(1363896240)},
+		{"\xa1\x61\x61\x82\x01\xf6", &any, map[interface{}]interface{}{"a": []interface{}{uint64(1), nil}}},
+		{"\xd8\x20\x61\x61", &any, Tag{Number: 32, Content: "a"}},
+	}
+	for _, c := range cases {
+		if err := Unmarshal([]byte(c.item), c.target); err != nil {
+			t.Errorf("%#v: unmarshal fail: %v", []byte(c.item), err)
+			continue
+		}
+		got := reflect.ValueOf(c.target).Elem()
+		if got.Kind() == reflect.Ptr {
+			got = got.Elem()
+		}
+		if !reflect.DeepEqual(got.Interface(), c.expect) {
+			t.Errorf("%#v: unmarshal got %#v", []byte(c.item), got.Interface())
+		}
+	}
+
+	ptr = new(int)
+	if err := Unmarshal([]byte("\xf6"), &ptr); err != nil || ptr != nil {
+		t.Log("unmarshal null into pointer fail")
+		t.Fail()
+	}
+
+	var typeerr *UnmarshalTypeError
+	if err := Unmarshal([]byte("\x19\x03\xe8"), &i8); !errors.As(err, &typeerr) {
+		t.Errorf("expected overflow error, got %v", err)
+	}
+	if err := Unmarshal([]byte("\x82\x01\x61\x61"), &ints); !errors.As(err, &typeerr) || typeerr.Offset != 2 {
+		t.Errorf("expected type error at offset 2, got %v", err)
+	}
+	if err := Unmarshal([]byte("\x20"), &u); !errors.As(err, &typeerr) {
+		t.Errorf("expected negative integer error, got %v", err)
+	}
+
+	// keys a Go map cannot hold, down to the content of a tag
+	var unsupported *UnsupportedTypeError
+	for _, item := range []string{"\xa1\x80\x00", "\xa1\xd8\x63\x40\x00", "\xa1\xd8\x63\x80\x00"} {
+		if err := Unmarshal([]byte(item), &any); !errors.As(err, &unsupported) {
+			t.Errorf("%#v: expected unsupported type error, got %v", []byte(item), err)
+		}
+	}
+	if err := Unmarshal([]byte("\xa1\xd8\x63\x01\x00"), &any); err != nil || any.(map[interface{}]interface{})[Tag{Number: 99, Content: uint64(1)}] != uint64(0) {
+		t.Errorf("unmarshal tag key got %#v, %v", any, err)
+	}
+
+	var invalid *InvalidUnmarshalError
+	if err := Unmarshal([]byte("\x01"), u); !errors.As(err, &invalid) {
+		t.Errorf("expected invalid unmarshal error, got %v", err)
+	}
+	var syntax *SyntaxError
+	if err := Unmarshal([]byte("\x82\x01"), &ints); !errors.As(err, &syntax) {
+		t.Errorf("expected syntax error, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package cbor
+
+import "io"
+import "bufio"
+import "bytes"
+
+// DecodeOptions bounds the resources a decoder may spend on one data item.
+// A zero limit means unlimited. Strict accepts only RFC 8949 core
+// deterministic encodings, as produced by CBOR_ENCODE_CORE_DETERMINISTIC.
+//
+// ZeroCopy makes definite-length strings reference the input instead of
+// copying it, so the input must stay unmodified for as long as the tree is
+// in use and StringBytes returns memory of the input. The BlobAppend
+// functions copy such a string before changing it, the input is never
+// written to. Indefinite-length strings are always copied.
+//
+// Tags registers conversions for tag numbers, see TagSet.
+type DecodeOptions struct {
+	MaxNestingDepth  int
+	MaxStringLength  int
+	MaxArrayElements int
+	MaxMapPairs      int
+	MaxTotalBytes    int
+	Strict           bool
+	ZeroCopy         bool
+	Tags             *TagSet
+}
+
+func (opts *DecodeOptions) check_depth(depth int, offset int) error {
+	if opts.MaxNestingDepth > 0 && depth >= opts.MaxNestingDepth {
+		return &LimitError{Offset: offset, Limit: "MaxNestingDepth"}
+	}
+	return nil
+}
+
+func (opts *DecodeOptions) check_length(ctype int, length uint64, offset int) error {
+	if ctype == CBOR_TYPE_BYTESTRING || ctype == CBOR_TYPE_STRING {
+		if opts.MaxStringLength > 0 && length > uint64(opts.MaxStringLength) {
+			return &LimitError{Offset: offset, Limit: "MaxStringLength"}
+		}
+	} else if ctype == CBOR_TYPE_ARRAY {
+		if opts.MaxArrayElements > 0 && length > uint64(opts.MaxArrayElements) {
+			return &LimitError{Offset: offset, Limit: "MaxArrayElements"}
+		}
+	} else if ctype == CBOR_TYPE_MAP {
+		if opts.MaxMapPairs > 0 && length > uint64(opts.MaxMapPairs) {
+			return &LimitError{Offset: offset, Limit: "MaxMapPairs"}
+		}
+	}
+	return nil
+}
+
+func (opts *DecodeOptions) check_total(total int, offset int) error {
+	if opts.MaxTotalBytes > 0 && total > opts.MaxTotalBytes {
+		return &LimitError{Offset: offset, Limit: "MaxTotalBytes"}
+	}
+	return nil
+}
+
+// check_head enforces the limits that can be decided from a head alone, the
+// length of an indefinite-length item is checked as it grows.
+func (opts *DecodeOptions) check_head(head cbor_head, offset int, depth int) error {
+	if head.ctype == CBOR_TYPE_ARRAY || head.ctype == CBOR_TYPE_MAP || head.ctype == CBOR_TYPE_TAG {
+		if err := opts.check_depth(depth, offset); err != nil {
+			return err
+		}
+	}
+	if head.addition == 31 || head.ctype == CBOR_TYPE_SIMPLE {
+		return nil
+	}
+	return opts.check_length(head.ctype, head.argument, offset)
+}
+
+// Decode decodes buf, which must hold exactly one data item.
+func (opts DecodeOptions) Decode(buf []byte) (*CborValue, error) {
+	if err := opts.check_total(len(buf), 0); err != nil {
+		return nil, err
+	}
+	val, rest, err := opts.DecodeFirst(buf)
+	if err == nil && len(rest) > 0 {
+		return nil, syntax_error(len(buf) - len(rest), int(rest[0] >> 5), CBOR_ERR_EXTRANEOUS_DATA)
+	}
+	return val, err
+}
+
+// DecodeFirst decodes the first data item of buf and returns the bytes
+// following it.
+func (opts DecodeOptions) DecodeFirst(buf []byte) (*CborValue, []byte, error) {
+	val, err, consume := cbor_parse(buf, 0, &opts, 0)
+	if err == nil {
+		err = opts.check_total(consume, 0)
+	}
+	if err != nil {
+		return nil, buf, err
+	}
+	return val, buf[consume:], nil
+}
+
+// Skip returns the length of the first data item of buf, checked as
+// DecodeFirst would check it but without building a CborValue and so without
+// running the conversions of Tags.
+func (opts DecodeOptions) Skip(buf []byte) (int, error) {
+	consume, err := cbor_skip(buf, 0, &opts, 0)
+	if err == nil {
+		err = opts.check_total(consume, 0)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return consume, nil
+}
+
+// Valid returns nil if buf holds exactly one well-formed data item, or the
+// error Decode would return for it. Like Skip it does not run the
+// conversions of Tags, Decode may still fail with a TagError.
+func (opts DecodeOptions) Valid(buf []byte) error {
+	if err := opts.check_total(len(buf), 0); err != nil {
+		return err
+	}
+	consume, err := opts.Skip(buf)
+	if err == nil && consume < len(buf) {
+		return syntax_error(consume, int(buf[consume] >> 5), CBOR_ERR_EXTRANEOUS_DATA)
+	}
+	return err
+}
+
+func (opts DecodeOptions) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), opts: opts}
+}
+
+type EncodeMode int
+
+const (
+	CBOR_ENCODE_DEFAULT EncodeMode = 0
+	// RFC 8949 §4.2.1 core deterministic encoding, map keys in bytewise
+	// lexicographic order of their encoding.
+	CBOR_ENCODE_CORE_DETERMINISTIC EncodeMode = 1
+	// RFC 7049 §3.9 canonical CBOR, shorter keys sort first.
+	CBOR_ENCODE_LENGTH_FIRST EncodeMode = 2
+	// CTAP2 canonical CBOR, keys sort by major type, then length-first.
+	CBOR_ENCODE_CTAP2 EncodeMode = 3
+)
+
+// EncodeOptions selects how values are serialized. Every mode writes the
+// shortest heads and preferred floats and never uses indefinite lengths, the
+// deterministic modes additionally sort map keys and canonicalize NaN. Tags
+// makes Marshal wrap values of registered types in their tag, TimeFormat
+// selects the tag Marshal writes a time.Time in. TypedArrays makes Marshal
+// write slices and arrays of numbers other than bytes as typed arrays, and
+// rectangular ones of those as multi-dimensional arrays, see NewTypedArray.
+type EncodeOptions struct {
+	Mode        EncodeMode
+	Tags        *TagSet
+	TimeFormat  TimeFormat
+	TypedArrays bool
+}
+
+func (opts *EncodeOptions) key_less(a []byte, b []byte) bool {
+	if opts.Mode == CBOR_ENCODE_CTAP2 && a[0] >> 5 != b[0] >> 5 {
+		return a[0] >> 5 < b[0] >> 5
+	}
+	if opts.Mode != CBOR_ENCODE_CORE_DETERMINISTIC && len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+func (opts EncodeOptions) Encode(val *CborValue) *bytes.Buffer {
+	var buf = new(bytes.Buffer)
+	cbor_dump(val, buf, &opts)
+	return buf
+}
+
+func (opts EncodeOptions) NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w), opts: opts}
+}
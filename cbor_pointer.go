@@ -0,0 +1,149 @@
+package cbor
+
+import "errors"
+import "strings"
+import "strconv"
+
+// ErrPointerNotFound is returned by GetRaw and GetValue when no item of the
+// document matches the pointer.
+var ErrPointerNotFound = errors.New("cbor: pointer not found")
+
+// pointer_key reports whether the map key encoded in buf[offset:end] matches
+// a JSON Pointer reference token, as pointer_pair does for a decoded map.
+func pointer_key(buf []byte, offset int, end int, ele string, integer interface{}) bool {
+	head, _, consume := read_head(buf, offset)
+	if head.ctype == CBOR_TYPE_STRING || head.ctype == CBOR_TYPE_BYTESTRING {
+		if head.addition != 31 {
+			return string(buf[offset + consume:end]) == ele
+		}
+		key, err, _ := cbor_parse(buf, offset, &DecodeOptions{}, 0)
+		return err == nil && key.Compare(ele)
+	} else if integer != nil && (head.ctype == CBOR_TYPE_UINT || head.ctype == CBOR_TYPE_NEGINT) {
+		key := CborValue{ctype: head.ctype, num: head.argument}
+		return key.Compare(integer)
+	}
+	return false
+}
+
+// pointer_entry returns the offset and depth of the entry of the array or
+// map at offset that the reference token ele designates, skipping the tags
+// around the container and the entries before it.
+func (opts *DecodeOptions) pointer_entry(buf []byte, offset int, depth int, ele string) (int, int, error) {
+	origin := offset
+	head, err, consume := read_head(buf, offset)
+	for {
+		if err != nil {
+			return 0, 0, err
+		}
+		if head.ctype != CBOR_TYPE_ARRAY && head.ctype != CBOR_TYPE_MAP && head.ctype != CBOR_TYPE_TAG {
+			return 0, 0, ErrPointerNotFound
+		}
+		if head.ctype == CBOR_TYPE_TAG && head.addition == 31 {
+			return 0, 0, syntax_error(origin, head.ctype, CBOR_ERR_INVALID_ADDITIONAL_INFO)
+		}
+		if err = opts.check_head(head, origin, depth); err != nil {
+			return 0, 0, err
+		}
+		if opts.Strict {
+			if err = check_deterministic(head, origin); err != nil {
+				return 0, 0, err
+			}
+		}
+		offset += consume
+		if head.ctype != CBOR_TYPE_TAG {
+			break
+		}
+		depth++
+		origin = offset
+		head, err, consume = read_head(buf, offset)
+	}
+
+	integer := pointer_integer(ele)
+	var index int64 = -1
+	if head.ctype == CBOR_TYPE_ARRAY && ele != "-" {
+		if index, err = strconv.ParseInt(ele, 10, 32); err != nil || index < 0 {
+			return 0, 0, ErrPointerNotFound
+		}
+	}
+	last := -1
+	for i := int64(0); head.addition == 31 || uint64(i) < head.argument; i++ {
+		if offset >= len(buf) {
+			return 0, 0, syntax_error(offset, -1, CBOR_ERR_TRUNCATED)
+		}
+		if head.addition == 31 && buf[offset] == 0xFF {
+			break
+		}
+		if head.ctype == CBOR_TYPE_MAP {
+			consume, err = cbor_skip(buf, offset, opts, depth + 1)
+			if err != nil {
+				return 0, 0, err
+			}
+			if pointer_key(buf, offset, offset + consume, ele, integer) {
+				return offset + consume, depth + 1, nil
+			}
+			offset += consume
+		} else if i == index {
+			return offset, depth + 1, nil
+		}
+		last = offset
+		consume, err = cbor_skip(buf, offset, opts, depth + 1)
+		if err != nil {
+			return 0, 0, err
+		}
+		offset += consume
+	}
+	if ele == "-" && head.ctype == CBOR_TYPE_ARRAY && last >= 0 {
+		return last, depth + 1, nil
+	}
+	return 0, 0, ErrPointerNotFound
+}
+
+// GetRaw returns the encoding of the item of buf that pointer designates, as
+// PointerGet would find it in the decoded document. Only the items on the
+// path and the siblings skipped to reach them are checked.
+func (opts DecodeOptions) GetRaw(buf []byte, pointer string) ([]byte, error) {
+	if err := opts.check_total(len(buf), 0); err != nil {
+		return nil, err
+	}
+	split := strings.Split(pointer, "/")
+	if split[0] != "" {
+		return nil, ErrPointerNotFound
+	}
+	offset := 0
+	depth := 0
+	for _, ele := range split[1:] {
+		ele = strings.Replace(ele, "~1", "/", -1)
+		ele = strings.Replace(ele, "~0", "~", -1)
+		next, next_depth, err := opts.pointer_entry(buf, offset, depth, ele)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+		depth = next_depth
+	}
+	consume, err := cbor_skip(buf, offset, &opts, depth)
+	if err != nil {
+		return nil, err
+	}
+	return buf[offset:offset + consume], nil
+}
+
+// GetValue decodes the item GetRaw returns.
+func (opts DecodeOptions) GetValue(buf []byte, pointer string) (*CborValue, error) {
+	raw, err := opts.GetRaw(buf, pointer)
+	if err != nil {
+		return nil, err
+	}
+	val, err, _ := cbor_parse(raw, 0, &opts, 0)
+	return val, err
+}
+
+// GetRaw returns the encoding of the item of buf that pointer designates
+// without decoding the rest of the document.
+func GetRaw(buf []byte, pointer string) ([]byte, error) {
+	return DecodeOptions{}.GetRaw(buf, pointer)
+}
+
+func GetValue(buf []byte, pointer string) (*CborValue, error) {
+	return DecodeOptions{}.GetValue(buf, pointer)
+}
@@ -0,0 +1,73 @@
+package cbor
+
+import "bytes"
+import "errors"
+import "testing"
+
+func TestGetRaw(t *testing.T) {
+	doc, _ := JSONDecode([]byte(` {
+            "foo": ["bar", "baz"],
+            "": 0,
+            "a/b": 1,
+            "m~n": 8,
+            "deep": {"list": [{"x": true}, null]}
+        }`))
+	buf := CBOREncode(doc).Bytes()
+
+	paths := []string{"", "/foo", "/foo/0", "/foo/1", "/foo/-", "/", "/a~1b", "/m~0n", "/deep/list/0/x", "/deep/list/1"}
+	for _, path := range paths {
+		val, err := GetValue(buf, path)
+		if err != nil {
+			t.Errorf("%q: get value fail: %v", path, err)
+			continue
+		}
+		expect := doc.PointerGet(path)
+		if !bytes.Equal(CBOREncode(val).Bytes(), CBOREncode(expect).Bytes()) {
+			t.Errorf("%q: got %s, expected %s", path, JSONEncode(val), JSONEncode(expect))
+		}
+	}
+
+	for _, path := range []string{"foo", "/bar", "/foo/2", "/foo/x", "/foo/0/a", "/deep/list/-1"} {
+		if _, err := GetRaw(buf, path); err != ErrPointerNotFound {
+			t.Errorf("%q: expected not found, got %v", path, err)
+		}
+	}
+
+	// {_ 1: [_ "a", h'02'], -2: "minus", "k": (_ "v", "w")}, trailing data after the target is not read
+	indef := []byte("\xbf\x01\x9f\x61a\x41\x02\xff\x21\x65minus\x61k\x7f\x61v\x61w\xff\xff")
+	cases := map[string]string{
+		"/1/1": "\x41\x02",
+		"/1/-": "\x41\x02",
+		"/-2":  "\x65minus",
+		"/k":   "\x7f\x61v\x61w\xff",
+	}
+	for path, expect := range cases {
+		if raw, err := GetRaw(indef, path); err != nil || !bytes.Equal(raw, []byte(expect)) {
+			t.Errorf("%q: got %#v, %v", path, raw, err)
+		}
+	}
+
+	// 55799({"a": 1(32([0, h'07']))})
+	tagged := []byte("\xd9\xd9\xf7\xa1\x61a\xc1\xd8\x20\x82\x00\x41\x07")
+	if raw, err := GetRaw(tagged, "/a/1"); err != nil || !bytes.Equal(raw, []byte("\x41\x07")) {
+		t.Errorf("get through tags got %#v, %v", raw, err)
+	}
+	if raw, err := GetRaw(tagged, "/a"); err != nil || !bytes.Equal(raw, tagged[6:]) {
+		t.Errorf("get tagged item got %#v, %v", raw, err)
+	}
+	var limit *LimitError
+	if _, err := (DecodeOptions{MaxNestingDepth: 3}).GetRaw(tagged, "/a/1"); !errors.As(err, &limit) {
+		t.Errorf("expected tags to count towards the depth limit, got %v", err)
+	}
+
+	var syntax *SyntaxError
+	if _, err := GetRaw([]byte("\xa2\x61a\x01\x61b"), "/c"); !errors.As(err, &syntax) || syntax.Kind != CBOR_ERR_TRUNCATED {
+		t.Errorf("expected truncated error, got %v", err)
+	}
+	if _, err := GetRaw([]byte("\x82\x01\x62\xff\xff\x03"), "/2"); !errors.As(err, &syntax) || syntax.Kind != CBOR_ERR_INVALID_UTF8 {
+		t.Errorf("expected utf-8 error in skipped sibling, got %v", err)
+	}
+	if _, err := (DecodeOptions{MaxNestingDepth: 1}).GetRaw([]byte("\x81\x81\x01"), "/0/0"); !errors.As(err, &limit) {
+		t.Errorf("expected limit error, got %v", err)
+	}
+}
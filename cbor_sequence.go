@@ -0,0 +1,130 @@
+package cbor
+
+import "io"
+import "bytes"
+
+// SequenceReader iterates the top-level data items of a CBOR Sequence
+// (RFC 8742), read from a buffer or a stream. Errors are sticky, a sequence
+// cannot be resynchronised after a malformed item.
+type SequenceReader struct {
+	buf    []byte
+	dec    *Decoder
+	opts   DecodeOptions
+	offset int
+	err    error
+}
+
+// NewSequence returns a SequenceReader over the items of buf.
+func NewSequence(buf []byte) *SequenceReader {
+	return DecodeOptions{}.NewSequence(buf)
+}
+
+// NewSequenceReader returns a SequenceReader over the items read from r.
+func NewSequenceReader(r io.Reader) *SequenceReader {
+	return DecodeOptions{}.NewSequenceReader(r)
+}
+
+func (opts DecodeOptions) NewSequence(buf []byte) *SequenceReader {
+	return &SequenceReader{buf: buf, opts: opts}
+}
+
+func (opts DecodeOptions) NewSequenceReader(r io.Reader) *SequenceReader {
+	return &SequenceReader{dec: opts.NewDecoder(r), opts: opts}
+}
+
+// Next decodes the next item. It returns io.EOF when the sequence ends
+// between items, a partial item is a SyntaxError over a buffer and
+// io.ErrUnexpectedEOF over a stream.
+func (seq *SequenceReader) Next() (*CborValue, error) {
+	if seq.err != nil {
+		return nil, seq.err
+	}
+	var val *CborValue = nil
+	var err error = nil
+	if seq.dec != nil {
+		val, err = seq.dec.Decode()
+		seq.offset = seq.dec.offset
+	} else if seq.offset >= len(seq.buf) {
+		err = io.EOF
+	} else {
+		var consume int
+		val, err, consume = cbor_parse(seq.buf, seq.offset, &seq.opts, 0)
+		if err == nil {
+			err = seq.opts.check_total(consume, seq.offset)
+		}
+		seq.offset += consume
+	}
+	if err != nil {
+		seq.err = err
+		return nil, err
+	}
+	return val, nil
+}
+
+// NextRaw checks the next item as Next does and returns its encoding without
+// decoding it. Over a buffer the result shares the memory of the buffer.
+func (seq *SequenceReader) NextRaw() ([]byte, error) {
+	if seq.err != nil {
+		return nil, seq.err
+	}
+	var raw []byte = nil
+	var err error = nil
+	if seq.dec != nil {
+		item := new(bytes.Buffer)
+		if _, err = seq.dec.read_item(item, 0); err == nil {
+			raw = item.Bytes()
+			_, err = cbor_skip(raw, 0, &seq.opts, 0)
+			err = shift_error(err, seq.dec.offset)
+			seq.dec.offset += len(raw)
+		}
+		seq.offset = seq.dec.offset
+	} else if seq.offset >= len(seq.buf) {
+		err = io.EOF
+	} else {
+		var consume int
+		consume, err = cbor_skip(seq.buf, seq.offset, &seq.opts, 0)
+		if err == nil {
+			err = seq.opts.check_total(consume, seq.offset)
+		}
+		raw = seq.buf[seq.offset:seq.offset + consume]
+		seq.offset += consume
+	}
+	if err != nil {
+		seq.err = err
+		return nil, err
+	}
+	return raw, nil
+}
+
+// Offset returns the position of the next item in the sequence.
+func (seq *SequenceReader) Offset() int {
+	return seq.offset
+}
+
+// SequenceWriter appends items to a CBOR Sequence, flushing after each one
+// so that a log written with it is always cut between items.
+type SequenceWriter struct {
+	enc *Encoder
+}
+
+func NewSequenceWriter(w io.Writer) *SequenceWriter {
+	return EncodeOptions{}.NewSequenceWriter(w)
+}
+
+func (opts EncodeOptions) NewSequenceWriter(w io.Writer) *SequenceWriter {
+	return &SequenceWriter{enc: opts.NewEncoder(w)}
+}
+
+func (seq *SequenceWriter) Write(val *CborValue) error {
+	return seq.enc.Encode(val)
+}
+
+// WriteRaw appends the encoding of an item, which must be exactly one
+// well-formed data item.
+func (seq *SequenceWriter) WriteRaw(raw []byte) error {
+	if err := Valid(raw); err != nil {
+		return err
+	}
+	seq.enc.w.Write(raw)
+	return seq.enc.w.Flush()
+}
@@ -0,0 +1,100 @@
+package cbor
+
+import "io"
+import "bytes"
+import "errors"
+import "testing"
+import "testing/iotest"
+
+func TestSequenceReader(t *testing.T) {
+	stream := new(bytes.Buffer)
+	for _, item := range content {
+		stream.WriteString(item)
+	}
+	data := stream.Bytes()
+
+	sources := []*SequenceReader{
+		NewSequence(data),
+		NewSequenceReader(iotest.OneByteReader(bytes.NewReader(data))),
+	}
+	for _, seq := range sources {
+		offset := 0
+		for idx, item := range content {
+			val, err := seq.Next()
+			if err != nil {
+				t.Fatalf("%d. sequence next fail: %v", idx, err)
+			}
+			expect, _ := CBORDecode([]byte(item))
+			if !bytes.Equal(CBOREncode(val).Bytes(), CBOREncode(expect).Bytes()) {
+				t.Errorf("%d. sequence item not equal: %#v", idx, []byte(item))
+			}
+			offset += len(item)
+			if seq.Offset() != offset {
+				t.Errorf("%d. sequence offset %d, expected %d", idx, seq.Offset(), offset)
+			}
+		}
+		if _, err := seq.Next(); err != io.EOF {
+			t.Errorf("expected io.EOF at end of sequence, got %v", err)
+		}
+	}
+
+	for _, seq := range []*SequenceReader{NewSequence(data), NewSequenceReader(bytes.NewReader(data))} {
+		for idx, item := range content {
+			raw, err := seq.NextRaw()
+			if err != nil || !bytes.Equal(raw, []byte(item)) {
+				t.Fatalf("%d. sequence raw got %#v, %v", idx, raw, err)
+			}
+		}
+		if _, err := seq.NextRaw(); err != io.EOF {
+			t.Errorf("expected io.EOF at end of sequence, got %v", err)
+		}
+	}
+}
+
+func TestSequenceReaderPartial(t *testing.T) {
+	data := []byte("\x01\x82\x02")
+	seq := NewSequence(data)
+	if val, err := seq.Next(); err != nil || val.Integer() != 1 {
+		t.Fatalf("sequence first item got %v", err)
+	}
+	var syntax *SyntaxError
+	if _, err := seq.Next(); !errors.As(err, &syntax) || syntax.Kind != CBOR_ERR_TRUNCATED || syntax.Offset != 3 {
+		t.Errorf("expected truncated error at 3, got %v", err)
+	}
+	if _, err := seq.NextRaw(); !errors.As(err, &syntax) {
+		t.Errorf("expected sticky error, got %v", err)
+	}
+
+	seq = NewSequenceReader(bytes.NewReader(data))
+	seq.NextRaw()
+	if _, err := seq.NextRaw(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	seq = NewSequenceReader(bytes.NewReader([]byte("\x01\x62\xff\xfe")))
+	seq.Next()
+	if _, err := seq.NextRaw(); !errors.As(err, &syntax) || syntax.Kind != CBOR_ERR_INVALID_UTF8 || syntax.Offset != 1 {
+		t.Errorf("expected utf-8 error at 1, got %v", err)
+	}
+}
+
+func TestSequenceWriter(t *testing.T) {
+	out := new(bytes.Buffer)
+	seq := NewSequenceWriter(out)
+	if err := seq.Write(NewInteger(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := seq.WriteRaw([]byte("\x82\x02\x03")); err != nil {
+		t.Fatal(err)
+	}
+	if err := seq.WriteRaw([]byte("\x82\x02")); err == nil {
+		t.Errorf("expected error writing a partial item")
+	}
+	if !bytes.Equal(out.Bytes(), []byte("\x01\x82\x02\x03")) {
+		t.Errorf("sequence writer got %x", out.Bytes())
+	}
+
+	if err := NewSequenceWriter(&failing_writer{}).Write(NewInteger(1)); err == nil {
+		t.Errorf("expected write error")
+	}
+}
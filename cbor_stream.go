@@ -0,0 +1,172 @@
+package cbor
+
+import "io"
+import "math"
+import "errors"
+import "bufio"
+import "bytes"
+
+type Decoder struct {
+	r      *bufio.Reader
+	opts   DecodeOptions
+	offset int
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return DecodeOptions{}.NewDecoder(r)
+}
+
+// read_item copies exactly one data item from the stream into item, so that
+// cbor_parse never sees a partial item. It returns the argument of the head,
+// which is the length of a definite-length string.
+func (dec *Decoder) read_item(item *bytes.Buffer, depth int) (uint64, error) {
+	initial, err := dec.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	origin := dec.offset + item.Len()
+	item.WriteByte(initial)
+	ctype := int(initial >> 5)
+	addition := int(initial & 0x1F)
+
+	var size uint64 = 0
+	if addition < 24 {
+		size = uint64(addition)
+	} else if addition <= 27 {
+		n := 1 << uint(addition-24)
+		for i := 0; i < n; i++ {
+			b, err := dec.r.ReadByte()
+			if err != nil {
+				return 0, unexpected_eof(err)
+			}
+			item.WriteByte(b)
+			size = size<<8 | uint64(b)
+		}
+	} else if addition != 31 || ctype < CBOR_TYPE_BYTESTRING || ctype > CBOR_TYPE_MAP {
+		if ctype == CBOR_TYPE_SIMPLE && addition == 31 {
+			return 0, syntax_error(origin, ctype, CBOR_ERR_UNEXPECTED_BREAK)
+		}
+		return 0, syntax_error(origin, ctype, CBOR_ERR_INVALID_ADDITIONAL_INFO)
+	}
+	if err = dec.opts.check_total(item.Len(), origin); err != nil {
+		return 0, err
+	}
+	if err = dec.opts.check_head(cbor_head{ctype, addition, size}, origin, depth); err != nil {
+		return 0, err
+	}
+
+	entry := 1
+	if ctype == CBOR_TYPE_MAP {
+		entry = 2
+	}
+
+	if addition == 31 {
+		var count uint64 = 0
+		for {
+			b, err := dec.r.ReadByte()
+			if err != nil {
+				return 0, unexpected_eof(err)
+			}
+			if b == 0xFF {
+				item.WriteByte(b)
+				return 0, dec.opts.check_total(item.Len(), origin)
+			}
+			dec.r.UnreadByte()
+			if ctype == CBOR_TYPE_BYTESTRING || ctype == CBOR_TYPE_STRING {
+				chunk, err := dec.read_item(item, depth)
+				if err != nil {
+					return 0, unexpected_eof(err)
+				}
+				count += chunk
+			} else {
+				for i := 0; i < entry; i++ {
+					if _, err = dec.read_item(item, depth + 1); err != nil {
+						return 0, unexpected_eof(err)
+					}
+				}
+				count++
+			}
+			if err = dec.opts.check_length(ctype, count, origin); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if ctype == CBOR_TYPE_BYTESTRING || ctype == CBOR_TYPE_STRING {
+		if size > uint64(math.MaxInt64 - item.Len()) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err = dec.opts.check_total(item.Len() + int(size), origin); err != nil {
+			return 0, err
+		}
+		n, err := io.CopyN(item, dec.r, int64(size))
+		if err != nil || uint64(n) != size {
+			return 0, unexpected_eof(err)
+		}
+	} else if ctype == CBOR_TYPE_ARRAY || ctype == CBOR_TYPE_MAP {
+		for i := uint64(0); i < size; i++ {
+			for j := 0; j < entry; j++ {
+				if _, err := dec.read_item(item, depth + 1); err != nil {
+					return 0, unexpected_eof(err)
+				}
+			}
+		}
+	} else if ctype == CBOR_TYPE_TAG {
+		if _, err := dec.read_item(item, depth + 1); err != nil {
+			return 0, unexpected_eof(err)
+		}
+	}
+	return size, nil
+}
+
+func unexpected_eof(err error) error {
+	if err == nil || err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Decode reads the next data item from the stream. It returns io.EOF when the
+// stream ends cleanly between items.
+func (dec *Decoder) Decode() (*CborValue, error) {
+	item := new(bytes.Buffer)
+	if _, err := dec.read_item(item, 0); err != nil {
+		return nil, err
+	}
+	val, err, _ := cbor_parse(item.Bytes(), 0, &dec.opts, 0)
+	err = shift_error(err, dec.offset)
+	dec.offset += item.Len()
+	return val, err
+}
+
+// shift_error moves the offset of an error found in an item read from the
+// stream to its position in the stream.
+func shift_error(err error, offset int) error {
+	var syntax *SyntaxError
+	var limit *LimitError
+	var tag *TagError
+	if errors.As(err, &tag) {
+		tag.Offset += offset
+	} else if errors.As(err, &syntax) {
+		syntax.Offset += offset
+	} else if errors.As(err, &limit) {
+		limit.Offset += offset
+	}
+	return err
+}
+
+type Encoder struct {
+	w    *bufio.Writer
+	opts EncodeOptions
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return EncodeOptions{}.NewEncoder(w)
+}
+
+// Encode writes val to the underlying writer and flushes it, returning the
+// first write error encountered.
+func (enc *Encoder) Encode(val *CborValue) error {
+	cbor_dump(val, enc.w, &enc.opts)
+	return enc.w.Flush()
+}
@@ -0,0 +1,90 @@
+package cbor
+
+import "io"
+import "bytes"
+import "testing"
+import "testing/iotest"
+
+func TestDecoder(t *testing.T) {
+	stream := new(bytes.Buffer)
+	for _, item := range content {
+		stream.WriteString(item)
+	}
+
+	dec := NewDecoder(iotest.OneByteReader(stream))
+	for idx, item := range content {
+		val, err := dec.Decode()
+		if err != nil {
+			t.Errorf("%d. stream decode fail: %v", idx, err)
+			return
+		}
+		expect, _ := CBORDecode([]byte(item))
+		if !bytes.Equal(CBOREncode(val).Bytes(), CBOREncode(expect).Bytes()) {
+			t.Errorf("%d. stream decode not equal: %#v", idx, []byte(item))
+		}
+	}
+
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	for _, item := range []string{"\x19\x03", "\x44\x01\x02", "\x83\x01\x02", "\x9f\x01\x02", "\x7f\x61\x61", "\xc1"} {
+		dec := NewDecoder(bytes.NewReader([]byte(item)))
+		if _, err := dec.Decode(); err != io.ErrUnexpectedEOF {
+			t.Errorf("decode truncated %#v: expected io.ErrUnexpectedEOF, got %v", []byte(item), err)
+		}
+	}
+}
+
+type failing_writer struct {
+	limit int
+}
+
+func (w *failing_writer) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.limit = 0
+		return 0, io.ErrShortWrite
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestEncoder(t *testing.T) {
+	out := new(bytes.Buffer)
+	enc := NewEncoder(out)
+	expect := new(bytes.Buffer)
+	for idx, item := range content {
+		val, _ := CBORDecode([]byte(item))
+		if err := enc.Encode(val); err != nil {
+			t.Errorf("%d. stream encode fail: %v", idx, err)
+		}
+		expect.Write(CBOREncode(val).Bytes())
+	}
+	if !bytes.Equal(out.Bytes(), expect.Bytes()) {
+		t.Log("stream encode not equal to CBOREncode")
+		t.Fail()
+	}
+
+	val := NewArray()
+	for i := 0; i < 10000; i++ {
+		val.ContainerInsertTail(NewString("0123456789"))
+	}
+	enc = NewEncoder(&failing_writer{limit: 1024})
+	if err := enc.Encode(val); err != io.ErrShortWrite {
+		t.Errorf("expected write error, got %v", err)
+	}
+}
+
+func TestDecoderSyntaxError(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader([]byte("\x01\x82\x01\x62\xc3\x28")))
+	if _, err := dec.Decode(); err != nil {
+		t.Errorf("decode first item fail: %v", err)
+	}
+	_, err := dec.Decode()
+	syntax, ok := err.(*SyntaxError)
+	if !ok || syntax.Offset != 3 || syntax.Kind != CBOR_ERR_INVALID_UTF8 {
+		t.Errorf("expected invalid utf-8 at offset 3, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package cbor
+
+import "fmt"
+import "reflect"
+
+// TagEncodeFunc returns the content of the tag a value of a registered type
+// encodes as. The result is marshaled like any other value.
+type TagEncodeFunc func(v interface{}) (interface{}, error)
+
+// TagDecodeFunc converts the content of a registered tag into a value of the
+// registered type.
+type TagDecodeFunc func(content *CborValue) (interface{}, error)
+
+type tag_entry struct {
+	number uint64
+	typ    reflect.Type
+	encode TagEncodeFunc
+	decode TagDecodeFunc
+}
+
+// TagSet maps tag numbers to Go types. Set as EncodeOptions.Tags, Marshal
+// wraps values of a registered type in their tag. Set as DecodeOptions.Tags,
+// Decode converts registered tags, failing with a TagError when the content
+// does not convert, and keeps the tag in the tree with the result available
+// from TagValue. Unmarshal stores them as the registered type, both into a
+// field of that type and into an interface{}. Tags that are not registered are handled as
+// without a TagSet. Register every tag before the set is used, a populated
+// TagSet may then be shared by any number of encoders and decoders.
+type TagSet struct {
+	numbers map[uint64]*tag_entry
+	types   map[reflect.Type]*tag_entry
+}
+
+func NewTagSet() *TagSet {
+	return &TagSet{numbers: map[uint64]*tag_entry{}, types: map[reflect.Type]*tag_entry{}}
+}
+
+// Register associates a tag number with a Go type. Either function may be
+// nil for a tag that is only encoded or only decoded. A number or a type can
+// be registered once.
+func (tags *TagSet) Register(number uint64, typ reflect.Type, encode TagEncodeFunc, decode TagDecodeFunc) error {
+	if typ == nil || (encode == nil && decode == nil) {
+		return fmt.Errorf("cbor: tag %d registered without a type or conversion", number)
+	}
+	if _, ok := tags.numbers[number]; ok {
+		return fmt.Errorf("cbor: tag %d already registered", number)
+	}
+	if _, ok := tags.types[typ]; ok {
+		return fmt.Errorf("cbor: type %s already registered", typ)
+	}
+	entry := &tag_entry{number: number, typ: typ, encode: encode, decode: decode}
+	tags.numbers[number] = entry
+	tags.types[typ] = entry
+	return nil
+}
+
+// decoder returns the entry that decodes tag number, if any.
+func (tags *TagSet) decoder(number uint64) *tag_entry {
+	if tags == nil {
+		return nil
+	}
+	if entry := tags.numbers[number]; entry != nil && entry.decode != nil {
+		return entry
+	}
+	return nil
+}
+
+// encoder returns the entry that encodes values of type t, if any.
+func (tags *TagSet) encoder(t reflect.Type) *tag_entry {
+	if tags == nil {
+		return nil
+	}
+	if entry := tags.types[t]; entry != nil && entry.encode != nil {
+		return entry
+	}
+	return nil
+}
+
+// convert runs the decode function of entry on content, checking that it
+// produced the registered type.
+func (entry *tag_entry) convert(content *CborValue, offset int) (reflect.Value, error) {
+	v, err := entry.decode(content)
+	if err == nil && (v == nil || !reflect.TypeOf(v).AssignableTo(entry.typ)) {
+		err = fmt.Errorf("decoded to %T, not %s", v, entry.typ)
+	}
+	if err != nil {
+		return reflect.Value{}, &TagError{Offset: offset, Number: entry.number, Err: err}
+	}
+	return reflect.ValueOf(v), nil
+}
+
+// Value converts a decoded item into the Go value an interface{} receives
+// from Unmarshal with this TagSet, registered tags becoming their type.
+func (tags *TagSet) Value(val *CborValue) (interface{}, error) {
+	return value_interface(val, tags)
+}
@@ -0,0 +1,150 @@
+package cbor
+
+import "bytes"
+import "errors"
+import "reflect"
+import "testing"
+
+type tagset_point struct {
+	X, Y int64
+}
+
+type tagset_shape struct {
+	Name   string
+	Origin tagset_point
+	Corner *tagset_point
+	Extra  interface{}
+}
+
+// point_tags registers tagset_point as an array [x, y] under number.
+func point_tags(t *testing.T, number uint64) *TagSet {
+	tags := NewTagSet()
+	encode := func(v interface{}) (interface{}, error) {
+		p := v.(tagset_point)
+		return []int64{p.X, p.Y}, nil
+	}
+	decode := func(content *CborValue) (interface{}, error) {
+		if !content.IsArray() || content.ContainerSize() != 2 || !content.ContainerAt(0).IsInteger() || !content.ContainerAt(1).IsInteger() {
+			return nil, errors.New("point is not an array of two integers")
+		}
+		return tagset_point{content.ContainerAt(0).Integer(), content.ContainerAt(1).Integer()}, nil
+	}
+	if err := tags.Register(number, reflect.TypeOf(tagset_point{}), encode, decode); err != nil {
+		t.Fatal(err)
+	}
+	return tags
+}
+
+func TestTagSet(t *testing.T) {
+	tags := point_tags(t, 40001)
+	enc := EncodeOptions{Tags: tags}
+	dec := DecodeOptions{Tags: tags}
+
+	shape := tagset_shape{Name: "box", Origin: tagset_point{1, 2}, Corner: &tagset_point{-3, 4}, Extra: tagset_point{5, 6}}
+	data, err := enc.Marshal(shape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := CBORDecode(data)
+	for _, path := range []string{"/Origin", "/Corner", "/Extra"} {
+		if doc.PointerGet(path).TagNumber() != 40001 || doc.PointerGet(path).TagContent().ContainerSize() != 2 {
+			t.Errorf("%s: expected tagged point, got %s", path, JSONEncode(doc.PointerGet(path)))
+		}
+	}
+	if plain, _ := Marshal(shape); bytes.Equal(plain, data) {
+		t.Errorf("marshal without a tag set used the registered tag")
+	}
+
+	var got tagset_shape
+	if err := dec.Unmarshal(data, &got); err != nil || !reflect.DeepEqual(got, shape) {
+		t.Errorf("unmarshal typed got %+v, %v", got, err)
+	}
+	var any interface{}
+	if err := dec.Unmarshal(data, &any); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := any.(map[interface{}]interface{}); m == nil || m["Origin"] != (tagset_point{1, 2}) {
+		t.Errorf("unmarshal interface got %#v", any)
+	}
+	if err := Unmarshal(data, &any); err != nil || any.(map[interface{}]interface{})["Origin"].(Tag).Number != 40001 {
+		t.Errorf("unmarshal without a tag set got %#v, %v", any, err)
+	}
+	if v, err := tags.Value(doc.PointerGet("/Corner")); err != nil || v != (tagset_point{-3, 4}) {
+		t.Errorf("tag set value got %#v, %v", v, err)
+	}
+
+	// unregistered tags are decoded as before
+	if err := dec.Unmarshal([]byte("\xd8\x20\x61a"), &any); err != nil || !reflect.DeepEqual(any, Tag{Number: 32, Content: "a"}) {
+		t.Errorf("unregistered tag got %#v, %v", any, err)
+	}
+
+	// [1, 40001([1, "x"])]
+	bad := []byte("\x82\x01\xd9\x9c\x41\x82\x01\x61x")
+	if _, err := CBORDecode(bad); err != nil {
+		t.Errorf("decode without a tag set fail: %v", err)
+	}
+	var tagerr *TagError
+	if _, err := dec.Decode(bad); !errors.As(err, &tagerr) || tagerr.Offset != 2 || tagerr.Number != 40001 {
+		t.Errorf("expected tag error at 2, got %v", err)
+	}
+	var point tagset_point
+	if err := dec.Unmarshal(bad[2:], &point); !errors.As(err, &tagerr) || tagerr.Offset != 0 {
+		t.Errorf("expected tag error from unmarshal, got %v", err)
+	}
+	if err := dec.Unmarshal(bad, &any); !errors.As(err, &tagerr) || tagerr.Offset != 2 {
+		t.Errorf("expected tag error from unmarshal into interface, got %v", err)
+	}
+
+	// Decode keeps the converted value, nothing converts it twice
+	calls := 0
+	counted := NewTagSet()
+	counted.Register(40005, reflect.TypeOf(""), nil, func(content *CborValue) (interface{}, error) {
+		calls++
+		return content.String(), nil
+	})
+	counting := DecodeOptions{Tags: counted}
+	item := []byte("\xd9\x9c\x45\x61a")
+	if val, err := counting.Decode(item); err != nil || val.TagValue() != "a" || val.Duplicate().TagValue() != "a" || calls != 1 {
+		t.Errorf("decode tag value got %#v, %v after %d conversions", val.TagValue(), err, calls)
+	}
+	var str string
+	if err := counting.Unmarshal(item, &str); err != nil || str != "a" || calls != 2 {
+		t.Errorf("unmarshal typed got %q, %v after %d conversions", str, err, calls)
+	}
+	if err := counting.Unmarshal(item, &any); err != nil || any != "a" || calls != 3 {
+		t.Errorf("unmarshal interface got %#v, %v after %d conversions", any, err, calls)
+	}
+	if val, _ := CBORDecode(item); val.TagValue() != nil {
+		t.Errorf("tag value without a tag set got %#v", val.TagValue())
+	}
+	if counting.Valid([]byte("\xd9\x9c\x45\x00")) != nil || calls != 3 {
+		t.Errorf("valid ran a tag conversion")
+	}
+
+	stream := dec.NewDecoder(bytes.NewReader(append([]byte("\x01"), bad...)))
+	stream.Decode()
+	if _, err := stream.Decode(); !errors.As(err, &tagerr) || tagerr.Offset != 3 {
+		t.Errorf("expected tag error at 3 in stream, got %v", err)
+	}
+
+	// registries are independent
+	other := point_tags(t, 40002)
+	data, _ = EncodeOptions{Tags: other}.Marshal(tagset_point{7, 8})
+	if !bytes.Equal(data, []byte("\xd9\x9c\x42\x82\x07\x08")) {
+		t.Errorf("second tag set got %x", data)
+	}
+	var p tagset_point
+	if err := dec.Unmarshal(data, &p); err == nil {
+		t.Errorf("expected error decoding a tag registered elsewhere")
+	}
+
+	if tags.Register(40001, reflect.TypeOf(""), nil, func(*CborValue) (interface{}, error) { return "", nil }) == nil {
+		t.Errorf("expected error registering a number twice")
+	}
+	if tags.Register(40003, reflect.TypeOf(tagset_point{}), nil, func(*CborValue) (interface{}, error) { return nil, nil }) == nil {
+		t.Errorf("expected error registering a type twice")
+	}
+	if tags.Register(40004, reflect.TypeOf(0), nil, nil) == nil {
+		t.Errorf("expected error registering without conversions")
+	}
+}
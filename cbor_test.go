@@ -1,5 +1,10 @@
 package cbor
 
+import "bytes"
+import "errors"
+import "fmt"
+import "math"
+import "strconv"
 import "testing"
 
 func TestNew(t *testing.T) {
@@ -219,6 +224,13 @@ func TestPointerGet(t *testing.T) {
 		t.Log("get /Bar fail")
 		t.Fail()
 	}
+
+	// {1: "one", -2: "minus two"}
+	m, _ := CBORDecode([]byte("\xa2\x01\x63one\x21\x69minus two"))
+	if m.PointerGet("/1").String() != "one" || m.PointerGet("/-2").String() != "minus two" {
+		t.Log("get integer key fail")
+		t.Fail()
+	}
 }
 
 func TestContainer(t *testing.T) {
@@ -297,4 +309,216 @@ func TestContainer(t *testing.T) {
 		t.Log("container last fail")
 		t.Fail()
 	}
+
+	for i := 0; i < v.ContainerSize(); i++ {
+		if ele = v.ContainerAt(i); ele == nil || ele.Integer() != int64(i) || v.ContainerIndexOf(ele) != i {
+			t.Logf("container at %d fail", i)
+			t.Fail()
+		}
+	}
+	if v.ContainerAt(-1) != nil || v.ContainerAt(4) != nil || v.ContainerIndexOf(NewInteger(0)) != -1 {
+		t.Log("container index out of range fail")
+		t.Fail()
+	}
+
+	ele = v.PointerGet("/1")
+	v.ContainerRemove(ele)
+	if v.ContainerIndexOf(ele) != -1 || v.ContainerIndexOf(v.ContainerLast()) != 2 {
+		t.Log("container index after remove fail")
+		t.Fail()
+	}
+	if v.ContainerSize() != 3 || v.ContainerNext(ele) != nil || v.PointerGet("/1").Integer() != 2 {
+		t.Log("container remove fail")
+		t.Fail()
+	}
+	if ele = v.ContainerPrev(v.ContainerLast()); ele == nil || ele.Integer() != 2 {
+		t.Log("container prev after remove fail")
+		t.Fail()
+	}
+	v.ContainerRemove(v.ContainerFirst())
+	v.ContainerRemove(v.ContainerLast())
+	v.ContainerRemove(v.ContainerLast())
+	if !v.ContainerEmpty() || v.ContainerFirst() != nil || v.ContainerLast() != nil {
+		t.Log("container empty after remove fail")
+		t.Fail()
+	}
+}
+
+func TestContainerRemoveHead(t *testing.T) {
+	v := NewArray()
+	for i := 0; i < 6; i++ {
+		v.ContainerInsertTail(New(i))
+	}
+	v.ContainerRemove(v.ContainerFirst())
+	v.ContainerRemove(v.ContainerFirst())
+	v.ContainerInsertHead(New(1))
+	v.ContainerInsertAfter(v.PointerGet("/1"), New(7))
+	v.ContainerInsertTail(New(8))
+	for i, expect := range []int64{1, 2, 7, 3, 4, 5, 8} {
+		ele := v.ContainerAt(i)
+		if ele == nil || ele.Integer() != expect || v.ContainerIndexOf(ele) != i {
+			t.Errorf("entry %d after head removal fail", i)
+		}
+		if i > 0 && v.ContainerPrev(ele) != v.ContainerAt(i - 1) {
+			t.Errorf("prev of entry %d after head removal fail", i)
+		}
+	}
+	for !v.ContainerEmpty() {
+		v.PointerRemove("/0")
+	}
+	v.ContainerInsertTail(New(9))
+	if v.ContainerIndexOf(v.ContainerFirst()) != 0 || v.PointerGet("/0").Integer() != 9 {
+		t.Errorf("insert into drained container fail")
+	}
+
+	m := NewMap()
+	for i := 0; i < 20; i++ {
+		m.MapSet(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 10; i++ {
+		m.ContainerRemove(m.ContainerFirst())
+	}
+	m.ContainerInsertHead(NewPair(NewString("15"), NewString("first")))
+	if m.MapGet("15").String() != "first" || m.PointerGet("/12").Integer() != 12 || m.MapGet("3") != nil {
+		t.Errorf("map after head removal fail")
+	}
+}
+
+func TestTag(t *testing.T) {
+	content := NewArray()
+	content.ContainerInsertTail(NewString("a"))
+	tag := NewTagged(1234, content)
+	if !tag.IsTag() || tag.TagNumber() != 1234 || tag.TagContent() != content || content.IsTag() || content.TagNumber() != 0 {
+		t.Log("new tagged fail")
+		t.Fail()
+	}
+	if again := NewTagged(5, content); again.TagContent() == content || !again.TagContent().IsArray() {
+		t.Log("new tagged copies owned content fail")
+		t.Fail()
+	}
+	if !NewTagged(6, nil).TagContent().IsNull() {
+		t.Log("new tagged nil content fail")
+		t.Fail()
+	}
+
+	// {"t": 1234(["a"]), "n": 1(1.5)}
+	doc := NewMap()
+	doc.ContainerInsertTail(NewPair(NewString("t"), tag))
+	doc.ContainerInsertTail(NewPair(NewString("n"), NewTagged(1, NewFloat(1.5))))
+	buf := CBOREncode(doc).Bytes()
+	if !bytes.Equal(buf, []byte("\xa2\x61t\xd9\x04\xd2\x81\x61a\x61n\xc1\xf9\x3e\x00")) {
+		t.Logf("encode tags got %x", buf)
+		t.Fail()
+	}
+	decoded, err := CBORDecode(buf)
+	if err != nil || decoded.PointerGet("/t").TagNumber() != 1234 || !decoded.PointerGet("/t").TagContent().IsArray() {
+		t.Log("decode tags fail")
+		t.Fail()
+	}
+	if !decoded.PointerGet("/n").Compare(Tag{Number: 1, Content: 1.5}) || decoded.PointerGet("/n").Compare(Tag{Number: 2, Content: 1.5}) ||
+		decoded.PointerGet("/n").Compare(1.5) {
+		t.Log("compare tag fail")
+		t.Fail()
+	}
+	if NewTag().Compare(Tag{Number: 0, Content: "a"}) || NewTag().Compare(Tag{}) {
+		t.Log("compare tag without content fail")
+		t.Fail()
+	}
+	if dup := decoded.Duplicate(); dup == nil || !bytes.Equal(CBOREncode(dup).Bytes(), buf) {
+		t.Log("duplicate tags fail")
+		t.Fail()
+	}
+	if s := JSONEncode(decoded).String(); s != `{"t": ["a"], "n": 1.500000}` {
+		t.Logf("json encode tags got %s", s)
+		t.Fail()
+	}
+
+	// pointers step through tags to the container they wrap
+	if decoded.PointerGet("/t/0").String() != "a" || decoded.PointerGet("/n/0") != nil {
+		t.Log("pointer get through tag fail")
+		t.Fail()
+	}
+	decoded.PointerAdd("/t/-", NewString("b"))
+	decoded.PointerMove("/t/0", "/t/-")
+	if decoded.PointerGet("/t/0").String() != "b" || decoded.PointerGet("/t/1").String() != "a" {
+		t.Log("pointer add and move through tag fail")
+		t.Fail()
+	}
+	if decoded.PointerRemove("/t/0").String() != "b" || decoded.PointerGet("/t").TagContent().ContainerSize() != 1 {
+		t.Log("pointer remove through tag fail")
+		t.Fail()
+	}
+	if NewTagged(7, doc).PointerGet("/t/0").String() != "a" {
+		t.Log("pointer get on tagged root fail")
+		t.Fail()
+	}
+
+	tag.SetTagContent(NewInteger(9))
+	if tag.TagContent().Integer() != 9 || content.parent != nil {
+		t.Log("set tag content fail")
+		t.Fail()
+	}
+	empty := NewTag()
+	empty.SetTagContent(NewString("x"))
+	if empty.TagContent().String() != "x" {
+		t.Log("set content of empty tag fail")
+		t.Fail()
+	}
+}
+
+func TestIntegerRange(t *testing.T) {
+	cases := []struct {
+		val    *CborValue
+		data   string
+		u      uint64
+		isuint bool
+		i      int64
+		err    error
+		big    string
+	}{
+		{NewUint64(math.MaxUint64), "\x1b\xff\xff\xff\xff\xff\xff\xff\xff", math.MaxUint64, true, 0, ErrIntegerOverflow, "18446744073709551615"},
+		{NewUint64(math.MaxInt64), "\x1b\x7f\xff\xff\xff\xff\xff\xff\xff", math.MaxInt64, true, math.MaxInt64, nil, "9223372036854775807"},
+		{NewNegative(math.MaxInt64), "\x3b\x7f\xff\xff\xff\xff\xff\xff\xff", 0, false, math.MinInt64, nil, "-9223372036854775808"},
+		{NewNegative(math.MaxInt64 + 1), "\x3b\x80\x00\x00\x00\x00\x00\x00\x00", 0, false, 0, ErrIntegerOverflow, "-9223372036854775809"},
+		{NewNegative(math.MaxUint64), "\x3b\xff\xff\xff\xff\xff\xff\xff\xff", 0, false, 0, ErrIntegerOverflow, "-18446744073709551616"},
+		{NewNegative(0), "\x20", 0, false, -1, nil, "-1"},
+	}
+	for _, c := range cases {
+		if buf := CBOREncode(c.val).Bytes(); !bytes.Equal(buf, []byte(c.data)) {
+			t.Errorf("%s: encode got %x", c.big, buf)
+		}
+		val, _ := CBORDecode([]byte(c.data))
+		if u, ok := val.Uint64(); u != c.u || ok != c.isuint {
+			t.Errorf("%s: uint64 got %d, %v", c.big, u, ok)
+		}
+		if i, err := val.Int64(); i != c.i || err != c.err {
+			t.Errorf("%s: int64 got %d, %v", c.big, i, err)
+		}
+		if val.BigInt().String() != c.big || val.Duplicate().BigInt().String() != c.big {
+			t.Errorf("%s: big int got %s", c.big, val.BigInt())
+		}
+	}
+
+	if v := New(uint64(math.MaxUint64)); !bytes.Equal(CBOREncode(v).Bytes(), []byte(cases[0].data)) {
+		t.Errorf("new uint64 got %x", CBOREncode(v).Bytes())
+	}
+	if _, err := NewString("1").Int64(); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("int64 of a string got %v", err)
+	}
+	if _, ok := NewInteger(-1).Uint64(); ok {
+		t.Errorf("uint64 of a negative integer fail")
+	}
+	val, _ := CBORDecode([]byte(cases[4].data))
+	if f := val.Float(); f != -18446744073709551616.0 {
+		t.Errorf("float of %s got %g", cases[4].big, f)
+	}
+	if f := NewNegative(1 << 63).Float(); f != -9223372036854775809.0 || NewNegative(0).Float() != -1 {
+		t.Errorf("float of %s got %g", cases[3].big, f)
+	}
+
+	// a negative integer below int64 does not wrap in an interface{}
+	var any interface{}
+	if err := Unmarshal([]byte(cases[4].data), &any); err != nil || fmt.Sprint(any) != cases[4].big {
+		t.Errorf("unmarshal into interface got %#v, %v", any, err)
+	}
 }
@@ -0,0 +1,195 @@
+package cbor
+
+import "fmt"
+import "math"
+import "time"
+import "errors"
+import "reflect"
+
+const (
+	CBOR_TAG_DATETIME      uint64 = 0    // RFC 3339 date/time string
+	CBOR_TAG_EPOCH         uint64 = 1    // seconds since the epoch, integer or float
+	CBOR_TAG_EPOCH_DAYS    uint64 = 100  // RFC 8943 days since 1970-01-01
+	CBOR_TAG_EXTENDED_TIME uint64 = 1001 // RFC 9581 extended time map
+	CBOR_TAG_FULL_DATE     uint64 = 1004 // RFC 8943 full-date string
+)
+
+type TimeFormat int
+
+const (
+	// tag 1, integer seconds, or a float when the time has a fraction
+	CBOR_TIME_EPOCH TimeFormat = 0
+	// tag 0, RFC 3339 string with nanoseconds and the offset of the time
+	CBOR_TIME_RFC3339 TimeFormat = 1
+	// tag 1001, map of integer seconds and nanoseconds, exact
+	CBOR_TIME_EXTENDED TimeFormat = 2
+	// tag 1004, the date of the time in its location, "2006-01-02"
+	CBOR_TIME_FULL_DATE TimeFormat = 3
+	// tag 100, the date of the time in its location as days since the epoch
+	CBOR_TIME_EPOCH_DAYS TimeFormat = 4
+)
+
+const seconds_per_day = 24 * 60 * 60
+
+// ErrNotTime is returned by Time for an item that is not in a date or time
+// tag.
+var ErrNotTime = errors.New("cbor: not a date or time tag")
+
+var time_type = reflect.TypeOf(time.Time{})
+
+// NewTime returns t as an epoch time, tag 1.
+func NewTime(t time.Time) *CborValue {
+	return NewTimeFormat(t, CBOR_TIME_EPOCH)
+}
+
+// NewTimeFormat returns t in one of the date and time tags.
+func NewTimeFormat(t time.Time, format TimeFormat) *CborValue {
+	switch format {
+	case CBOR_TIME_RFC3339:
+		return NewTagged(CBOR_TAG_DATETIME, NewString(t.Format(time.RFC3339Nano)))
+	case CBOR_TIME_EXTENDED:
+		content := NewMap()
+		content.ContainerInsertTail(NewPair(NewInteger(1), NewInteger(t.Unix())))
+		if t.Nanosecond() != 0 {
+			content.ContainerInsertTail(NewPair(NewInteger(-9), NewInteger(int64(t.Nanosecond()))))
+		}
+		return NewTagged(CBOR_TAG_EXTENDED_TIME, content)
+	case CBOR_TIME_FULL_DATE:
+		return NewTagged(CBOR_TAG_FULL_DATE, NewString(t.Format("2006-01-02")))
+	case CBOR_TIME_EPOCH_DAYS:
+		y, m, d := t.Date()
+		days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / seconds_per_day
+		return NewTagged(CBOR_TAG_EPOCH_DAYS, NewInteger(days))
+	}
+	if t.Nanosecond() == 0 {
+		return NewTagged(CBOR_TAG_EPOCH, NewInteger(t.Unix()))
+	}
+	return NewTagged(CBOR_TAG_EPOCH, NewFloat(float64(t.Unix()) + float64(t.Nanosecond()) / 1e9))
+}
+
+// int64_value returns the integer val holds if it fits an int64.
+func int64_value(val *CborValue) (int64, bool) {
+	i, err := val.Int64()
+	return i, err == nil
+}
+
+// epoch_time converts seconds since the epoch, an integer or a float.
+func epoch_time(val *CborValue) (time.Time, error) {
+	if sec, ok := int64_value(val); ok {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	if val.IsFloat() {
+		f := val.Float()
+		if math.IsNaN(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+			return time.Time{}, fmt.Errorf("epoch time %v out of range", f)
+		}
+		sec := math.Floor(f)
+		nsec := math.Round((f - sec) * 1e9)
+		return time.Unix(int64(sec), int64(nsec)).UTC(), nil
+	}
+	return time.Time{}, errors.New("epoch time is not a number")
+}
+
+// extended_time converts the map of tag 1001, a base time under key 1 or -1
+// and at most one fraction under -3, -6 or -9.
+func extended_time(val *CborValue) (time.Time, error) {
+	if !val.IsMap() {
+		return time.Time{}, errors.New("extended time is not a map")
+	}
+	var t time.Time
+	var nsec int64 = -1
+	base := false
+	for pair := val.ContainerFirst(); pair != nil; pair = val.ContainerNext(pair) {
+		key, ok := int64_value(pair.PairKey())
+		if !ok {
+			continue
+		}
+		value := pair.PairValue()
+		switch key {
+		case 1, -1:
+			if base {
+				return time.Time{}, errors.New("extended time has two base times")
+			}
+			var err error
+			if key == 1 && !value.IsInteger() {
+				err = errors.New("extended time base is not an integer")
+			} else if key == -1 && !value.IsFloat() {
+				err = errors.New("extended time base is not a float")
+			} else {
+				t, err = epoch_time(value)
+			}
+			if err != nil {
+				return time.Time{}, err
+			}
+			base = true
+		case -3, -6, -9:
+			var limit int64 = 1000
+			if key == -6 {
+				limit = 1000000
+			} else if key == -9 {
+				limit = 1000000000
+			}
+			n, ok := int64_value(value)
+			if nsec >= 0 || !ok || n < 0 || n >= limit {
+				return time.Time{}, errors.New("extended time has an invalid fraction")
+			}
+			nsec = n * (1000000000 / limit)
+		}
+	}
+	if !base {
+		return time.Time{}, errors.New("extended time has no base time")
+	}
+	if nsec > 0 {
+		t = t.Add(time.Duration(nsec))
+	}
+	return t, nil
+}
+
+// Time converts an item in one of the date and time tags, 0, 1, 100, 1001
+// or 1004. Epoch times and dates are returned in UTC, RFC 3339 times keep
+// their offset.
+func (val *CborValue) Time() (time.Time, error) {
+	if !val.IsTag() {
+		return time.Time{}, ErrNotTime
+	}
+	var t time.Time
+	var err error
+	content := val.TagContent()
+	switch val.num {
+	case CBOR_TAG_DATETIME:
+		if content == nil || content.ctype != CBOR_TYPE_STRING {
+			err = errors.New("date/time is not a text string")
+		} else {
+			t, err = time.Parse(time.RFC3339Nano, content.String())
+		}
+	case CBOR_TAG_EPOCH:
+		t, err = epoch_time(content)
+	case CBOR_TAG_EPOCH_DAYS:
+		days, ok := int64_value(content)
+		if !ok || days > math.MaxInt64 / seconds_per_day || days < math.MinInt64 / seconds_per_day {
+			err = errors.New("days are not an integer in range")
+		} else {
+			t = time.Unix(days * seconds_per_day, 0).UTC()
+		}
+	case CBOR_TAG_EXTENDED_TIME:
+		t, err = extended_time(content)
+	case CBOR_TAG_FULL_DATE:
+		if content == nil || content.ctype != CBOR_TYPE_STRING {
+			err = errors.New("full-date is not a text string")
+		} else {
+			t, err = time.Parse("2006-01-02", content.String())
+		}
+	default:
+		return time.Time{}, ErrNotTime
+	}
+	if err != nil {
+		return time.Time{}, &TagError{Number: val.num, Err: err}
+	}
+	return t, nil
+}
+
+// is_time_tag reports whether number is one Time converts.
+func is_time_tag(number uint64) bool {
+	return number == CBOR_TAG_DATETIME || number == CBOR_TAG_EPOCH || number == CBOR_TAG_EPOCH_DAYS ||
+		number == CBOR_TAG_EXTENDED_TIME || number == CBOR_TAG_FULL_DATE
+}
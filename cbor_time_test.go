@@ -0,0 +1,115 @@
+package cbor
+
+import "bytes"
+import "errors"
+import "testing"
+import "time"
+
+func TestTime(t *testing.T) {
+	moment := time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)
+	fraction := moment.Add(500 * time.Millisecond)
+	exact := moment.Add(123456789)
+	zone := time.FixedZone("", -5 * 3600)
+	cases := []struct {
+		t      time.Time
+		format TimeFormat
+		data   string
+	}{
+		{moment, CBOR_TIME_EPOCH, "\xc1\x1a\x51\x4b\x67\xb0"},
+		{fraction, CBOR_TIME_EPOCH, "\xc1\xfb\x41\xd4\x52\xd9\xec\x20\x00\x00"},
+		{moment, CBOR_TIME_RFC3339, "\xc0\x742013-03-21T20:04:00Z"},
+		{exact.In(zone), CBOR_TIME_RFC3339, "\xc0\x78\x232013-03-21T15:04:00.123456789-05:00"},
+		{exact, CBOR_TIME_EXTENDED, "\xd9\x03\xe9\xa2\x01\x1a\x51\x4b\x67\xb0\x28\x1a\x07\x5b\xcd\x15"},
+		{moment, CBOR_TIME_EXTENDED, "\xd9\x03\xe9\xa1\x01\x1a\x51\x4b\x67\xb0"},
+		{moment, CBOR_TIME_FULL_DATE, "\xd9\x03\xec\x6a2013-03-21"},
+		{moment, CBOR_TIME_EPOCH_DAYS, "\xd8\x64\x19\x3d\xa9"},
+		{time.Date(1940, 10, 9, 0, 0, 0, 0, time.UTC), CBOR_TIME_EPOCH_DAYS, "\xd8\x64\x39\x29\xb3"},
+	}
+	for idx, c := range cases {
+		val := NewTimeFormat(c.t, c.format)
+		if buf := CBOREncode(val).Bytes(); !bytes.Equal(buf, []byte(c.data)) {
+			t.Errorf("%d. encode time got %x", idx, buf)
+		}
+		data, err := EncodeOptions{TimeFormat: c.format}.Marshal(c.t)
+		if err != nil || !bytes.Equal(data, []byte(c.data)) {
+			t.Errorf("%d. marshal time got %x, %v", idx, data, err)
+		}
+
+		expect := c.t
+		if c.format == CBOR_TIME_FULL_DATE || c.format == CBOR_TIME_EPOCH_DAYS {
+			expect = time.Date(c.t.Year(), c.t.Month(), c.t.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		decoded, _ := CBORDecode([]byte(c.data))
+		if got, err := decoded.Time(); err != nil || !got.Equal(expect) {
+			t.Errorf("%d. time got %v, %v", idx, got, err)
+		}
+		var got time.Time
+		if err := Unmarshal([]byte(c.data), &got); err != nil || !got.Equal(expect) {
+			t.Errorf("%d. unmarshal time got %v, %v", idx, got, err)
+		}
+		var any interface{}
+		if err := Unmarshal([]byte(c.data), &any); err != nil || !any.(time.Time).Equal(expect) {
+			t.Errorf("%d. unmarshal interface got %#v, %v", idx, any, err)
+		}
+	}
+
+	if !NewTime(moment).Compare(Tag{Number: 1, Content: 1363896240}) {
+		t.Errorf("new time is not an epoch tag")
+	}
+	millis, _ := CBORDecode([]byte("\xd9\x03\xe9\xa2\x01\x00\x22\x19\x01\xf4"))
+	if got, err := millis.Time(); err != nil || got.Nanosecond() != 500000000 {
+		t.Errorf("extended time in milliseconds got %v, %v", got, err)
+	}
+
+	// untagged strings and numbers, a pointer and null
+	type event struct {
+		At   time.Time
+		Seen *time.Time
+	}
+	var e event
+	if err := Unmarshal([]byte("\xa2\x62At\x742013-03-21T20:04:00Z\x64Seen\x1a\x51\x4b\x67\xb0"), &e); err != nil ||
+		!e.At.Equal(moment) || e.Seen == nil || !e.Seen.Equal(moment) {
+		t.Errorf("unmarshal untagged times got %+v, %v", e, err)
+	}
+	if err := Unmarshal([]byte("\xa1\x64Seen\xf6"), &e); err != nil || e.Seen != nil {
+		t.Errorf("unmarshal null time got %+v, %v", e, err)
+	}
+
+	bad := []string{
+		"\xc0\x01",
+		"\xc0\x63now",
+		"\xc1\x61x",
+		"\xc1\xf9\x7e\x00",
+		"\xd8\x64\x3b\x7f\xff\xff\xff\xff\xff\xff\xff",
+		"\xd9\x03\xe9\xa0",
+		"\xd9\x03\xe9\xa2\x01\x00\x28\x3a\x3b\x9a\xca\x00",
+		"\xd9\x03\xec\x6a2013-02-30",
+	}
+	for _, data := range bad {
+		val, _ := CBORDecode([]byte(data))
+		var tagerr *TagError
+		if _, err := val.Time(); !errors.As(err, &tagerr) {
+			t.Errorf("%#v: expected tag error, got %v", []byte(data), err)
+		}
+		var got time.Time
+		if err := Unmarshal([]byte(data), &got); !errors.As(err, &tagerr) {
+			t.Errorf("%#v: expected tag error from unmarshal, got %v", []byte(data), err)
+		}
+		var any interface{}
+		if err := Unmarshal([]byte(data), &any); err != nil || any.(Tag).Number != val.TagNumber() {
+			t.Errorf("%#v: expected plain tag, got %#v, %v", []byte(data), any, err)
+		}
+	}
+	if _, err := NewInteger(1).Time(); err != ErrNotTime {
+		t.Errorf("expected ErrNotTime, got %v", err)
+	}
+	var got time.Time
+	if err := Unmarshal([]byte("\xd8\x20\x61a"), &got); err == nil {
+		t.Errorf("expected error for a non-time tag")
+	}
+	var times []time.Time
+	var tagerr *TagError
+	if err := Unmarshal([]byte("\x82\xc1\x00\xc0\x61x"), &times); !errors.As(err, &tagerr) || tagerr.Offset != 3 {
+		t.Errorf("expected tag error at 3, got %v", err)
+	}
+}
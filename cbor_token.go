@@ -0,0 +1,342 @@
+package cbor
+
+import "io"
+import "fmt"
+import "math"
+import "bufio"
+import "bytes"
+import "unicode/utf8"
+
+type TokenKind int
+
+const (
+	CBOR_TOKEN_START_ARRAY TokenKind = 1
+	CBOR_TOKEN_START_MAP   TokenKind = 2
+	CBOR_TOKEN_KEY         TokenKind = 3
+	CBOR_TOKEN_UINT        TokenKind = 4
+	CBOR_TOKEN_NEGINT      TokenKind = 5
+	CBOR_TOKEN_BYTES       TokenKind = 6
+	CBOR_TOKEN_TEXT        TokenKind = 7
+	CBOR_TOKEN_TAG         TokenKind = 8
+	CBOR_TOKEN_SIMPLE      TokenKind = 9
+	CBOR_TOKEN_FLOAT       TokenKind = 10
+	CBOR_TOKEN_BREAK       TokenKind = 11
+	CBOR_TOKEN_END         TokenKind = 12
+)
+
+func (kind TokenKind) String() string {
+	switch kind {
+	case CBOR_TOKEN_START_ARRAY:
+		return "start array"
+	case CBOR_TOKEN_START_MAP:
+		return "start map"
+	case CBOR_TOKEN_KEY:
+		return "key"
+	case CBOR_TOKEN_UINT:
+		return "unsigned integer"
+	case CBOR_TOKEN_NEGINT:
+		return "negative integer"
+	case CBOR_TOKEN_BYTES:
+		return "byte string"
+	case CBOR_TOKEN_TEXT:
+		return "text string"
+	case CBOR_TOKEN_TAG:
+		return "tag"
+	case CBOR_TOKEN_SIMPLE:
+		return "simple value"
+	case CBOR_TOKEN_FLOAT:
+		return "float"
+	case CBOR_TOKEN_BREAK:
+		return "break"
+	case CBOR_TOKEN_END:
+		return "end"
+	}
+	return fmt.Sprintf("token kind %d", int(kind))
+}
+
+// Token is one event of a Tokenizer. Value is the argument of the head: the
+// integer, where a negative integer is -1 - Value, the tag number, the simple
+// value, the bit pattern of a float, the number of elements or pairs of a
+// container or the length of a string. Indefinite marks a container or a
+// string whose chunks follow as separate tokens, either ends with a Break.
+// Key precedes every map key, End follows the last entry of a definite
+// container, both are at the offset of the next byte.
+type Token struct {
+	Kind       TokenKind
+	Offset     int
+	Value      uint64
+	Float      float64
+	Bytes      []byte
+	Indefinite bool
+}
+
+type token_frame struct {
+	ctype      int
+	indefinite bool
+	remaining  uint64
+	items      uint64
+	keyed      bool
+}
+
+// Tokenizer reads data items one token at a time without building a tree.
+// Consecutive top-level items are read in turn, Next returns io.EOF when the
+// input ends between them.
+type Tokenizer struct {
+	buf    []byte
+	r      *bufio.Reader
+	offset int
+	stack  []token_frame
+	err    error
+}
+
+// NewTokenizer returns a Tokenizer over buf, the Bytes of its tokens share
+// the memory of buf.
+func NewTokenizer(buf []byte) *Tokenizer {
+	return &Tokenizer{buf: buf}
+}
+
+// NewTokenReader returns a Tokenizer reading from r. Input that ends inside a
+// data item is reported as io.ErrUnexpectedEOF, as Decoder does.
+func NewTokenReader(r io.Reader) *Tokenizer {
+	return &Tokenizer{r: bufio.NewReader(r)}
+}
+
+// truncated reports the end of input at origin, inside the item of type ctype.
+func (t *Tokenizer) truncated(origin int, ctype int) error {
+	if t.r != nil {
+		return io.ErrUnexpectedEOF
+	}
+	return syntax_error(origin, ctype, CBOR_ERR_TRUNCATED)
+}
+
+// peek returns the next byte without consuming it, or io.EOF at the end of
+// the input.
+func (t *Tokenizer) peek() (byte, error) {
+	if t.r != nil {
+		b, err := t.r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		return b[0], nil
+	}
+	if t.offset >= len(t.buf) {
+		return 0, io.EOF
+	}
+	return t.buf[t.offset], nil
+}
+
+// read consumes n bytes of the item of type ctype that starts at origin.
+func (t *Tokenizer) read(n uint64, origin int, ctype int) ([]byte, error) {
+	if t.r != nil {
+		if n > math.MaxInt64 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		var blob bytes.Buffer
+		copied, err := io.CopyN(&blob, t.r, int64(n))
+		t.offset += int(copied)
+		if err != nil {
+			return nil, unexpected_eof(err)
+		}
+		return blob.Bytes(), nil
+	}
+	if n > uint64(len(t.buf) - t.offset) {
+		return nil, syntax_error(origin, ctype, CBOR_ERR_TRUNCATED)
+	}
+	blob := t.buf[t.offset:t.offset + int(n)]
+	t.offset += int(n)
+	return blob, nil
+}
+
+// Next returns the next token. Errors are sticky, once Next fails it keeps
+// returning the same error.
+func (t *Tokenizer) Next() (Token, error) {
+	if t.err != nil {
+		return Token{}, t.err
+	}
+	tok, err := t.next()
+	if err != nil {
+		t.err = err
+	}
+	return tok, err
+}
+
+func (t *Tokenizer) next() (Token, error) {
+	var top *token_frame = nil
+	for len(t.stack) > 0 {
+		top = &t.stack[len(t.stack) - 1]
+		if top.indefinite || top.remaining > 0 {
+			break
+		}
+		ctype := top.ctype
+		t.stack = t.stack[:len(t.stack) - 1]
+		top = nil
+		if ctype == CBOR_TYPE_ARRAY || ctype == CBOR_TYPE_MAP {
+			return Token{Kind: CBOR_TOKEN_END, Offset: t.offset}, nil
+		}
+	}
+
+	initial, err := t.peek()
+	if err == io.EOF && top != nil {
+		return Token{}, t.truncated(t.offset, -1)
+	} else if err != nil {
+		return Token{}, err
+	}
+	if top != nil && top.ctype == CBOR_TYPE_MAP && top.items % 2 == 0 && !top.keyed && initial != 0xFF {
+		top.keyed = true
+		return Token{Kind: CBOR_TOKEN_KEY, Offset: t.offset}, nil
+	}
+
+	origin := t.offset
+	ctype := int(initial >> 5)
+	addition := int(initial & 0x1F)
+	if initial == 0xFF {
+		if top == nil || !top.indefinite || (top.ctype == CBOR_TYPE_MAP && top.items % 2 == 1) {
+			return Token{}, syntax_error(origin, ctype, CBOR_ERR_UNEXPECTED_BREAK)
+		}
+		t.read(1, origin, ctype)
+		t.stack = t.stack[:len(t.stack) - 1]
+		return Token{Kind: CBOR_TOKEN_BREAK, Offset: origin}, nil
+	}
+	if top != nil && (top.ctype == CBOR_TYPE_BYTESTRING || top.ctype == CBOR_TYPE_STRING) &&
+		(ctype != top.ctype || addition == 31) {
+		return Token{}, syntax_error(origin, ctype, CBOR_ERR_INVALID_CHUNK)
+	}
+
+	head := cbor_head{ctype: ctype, addition: addition}
+	t.read(1, origin, ctype)
+	if addition < 24 {
+		head.argument = uint64(addition)
+	} else if addition <= 27 {
+		arg, err := t.read(1 << uint(addition - 24), origin, ctype)
+		if err != nil {
+			return Token{}, err
+		}
+		head.argument = read_network_endian(arg, 0, len(arg))
+	} else if addition != 31 || ctype == CBOR_TYPE_UINT || ctype == CBOR_TYPE_NEGINT ||
+		ctype == CBOR_TYPE_TAG || ctype == CBOR_TYPE_SIMPLE {
+		return Token{}, syntax_error(origin, ctype, CBOR_ERR_INVALID_ADDITIONAL_INFO)
+	}
+
+	if top != nil {
+		top.items++
+		if top.ctype != CBOR_TYPE_MAP || top.items % 2 == 0 {
+			top.keyed = false
+			if !top.indefinite {
+				top.remaining--
+			}
+		}
+	}
+
+	tok := Token{Offset: origin, Value: head.argument, Indefinite: addition == 31}
+	switch ctype {
+	case CBOR_TYPE_UINT:
+		tok.Kind = CBOR_TOKEN_UINT
+	case CBOR_TYPE_NEGINT:
+		tok.Kind = CBOR_TOKEN_NEGINT
+	case CBOR_TYPE_BYTESTRING, CBOR_TYPE_STRING:
+		tok.Kind = CBOR_TOKEN_BYTES
+		if ctype == CBOR_TYPE_STRING {
+			tok.Kind = CBOR_TOKEN_TEXT
+		}
+		if tok.Indefinite {
+			tok.Value = 0
+			t.stack = append(t.stack, token_frame{ctype: ctype, indefinite: true})
+			break
+		}
+		blob, err := t.read(head.argument, origin, ctype)
+		if err != nil {
+			return Token{}, err
+		}
+		if ctype == CBOR_TYPE_STRING && !utf8.Valid(blob) {
+			return Token{}, syntax_error(origin, ctype, CBOR_ERR_INVALID_UTF8)
+		}
+		tok.Bytes = blob
+	case CBOR_TYPE_ARRAY, CBOR_TYPE_MAP:
+		tok.Kind = CBOR_TOKEN_START_ARRAY
+		if ctype == CBOR_TYPE_MAP {
+			tok.Kind = CBOR_TOKEN_START_MAP
+		}
+		if tok.Indefinite {
+			tok.Value = 0
+		}
+		t.stack = append(t.stack, token_frame{ctype: ctype, indefinite: tok.Indefinite, remaining: tok.Value})
+	case CBOR_TYPE_TAG:
+		tok.Kind = CBOR_TOKEN_TAG
+		t.stack = append(t.stack, token_frame{ctype: ctype, remaining: 1})
+	case CBOR_TYPE_SIMPLE:
+		if addition == 24 && head.argument < 32 {
+			return Token{}, syntax_error(origin, ctype, CBOR_ERR_INVALID_ADDITIONAL_INFO)
+		}
+		tok.Kind = CBOR_TOKEN_SIMPLE
+		if addition == 25 {
+			tok.Kind = CBOR_TOKEN_FLOAT
+			tok.Float = float16_to_float64(uint16(head.argument))
+		} else if addition == 26 {
+			tok.Kind = CBOR_TOKEN_FLOAT
+			tok.Float = float32_to_float64(uint32(head.argument))
+		} else if addition == 27 {
+			tok.Kind = CBOR_TOKEN_FLOAT
+			tok.Float = math.Float64frombits(head.argument)
+		}
+	}
+	return tok, nil
+}
+
+var token_types = map[TokenKind]int{
+	CBOR_TOKEN_BYTES:       CBOR_TYPE_BYTESTRING,
+	CBOR_TOKEN_TEXT:        CBOR_TYPE_STRING,
+	CBOR_TOKEN_START_ARRAY: CBOR_TYPE_ARRAY,
+	CBOR_TOKEN_START_MAP:   CBOR_TYPE_MAP,
+}
+
+// TokenWriter encodes a sequence of tokens, as read from a Tokenizer or
+// built by hand, in the preferred serialization. It trusts the sequence to
+// be well formed: Key and End write nothing and the Value of a Float token
+// is ignored in favour of Float.
+type TokenWriter struct {
+	w *bufio.Writer
+}
+
+func NewTokenWriter(w io.Writer) *TokenWriter {
+	return &TokenWriter{w: bufio.NewWriter(w)}
+}
+
+// WriteToken buffers the encoding of tok, call Flush once done.
+func (tw *TokenWriter) WriteToken(tok Token) error {
+	switch tok.Kind {
+	case CBOR_TOKEN_KEY, CBOR_TOKEN_END:
+	case CBOR_TOKEN_UINT:
+		write_head(tw.w, CBOR_TYPE_UINT, tok.Value)
+	case CBOR_TOKEN_NEGINT:
+		write_head(tw.w, CBOR_TYPE_NEGINT, tok.Value)
+	case CBOR_TOKEN_TAG:
+		write_head(tw.w, CBOR_TYPE_TAG, tok.Value)
+	case CBOR_TOKEN_BYTES, CBOR_TOKEN_TEXT, CBOR_TOKEN_START_ARRAY, CBOR_TOKEN_START_MAP:
+		ctype := token_types[tok.Kind]
+		if tok.Indefinite {
+			tw.w.WriteByte(uint8(ctype << 5 | 31))
+		} else if ctype == CBOR_TYPE_BYTESTRING || ctype == CBOR_TYPE_STRING {
+			write_head(tw.w, ctype, uint64(len(tok.Bytes)))
+			tw.w.Write(tok.Bytes)
+		} else {
+			write_head(tw.w, ctype, tok.Value)
+		}
+	case CBOR_TOKEN_SIMPLE:
+		if tok.Value > 0xFF || (tok.Value >= 24 && tok.Value < 32) {
+			return fmt.Errorf("cbor: invalid simple value %d", tok.Value)
+		}
+		write_head(tw.w, CBOR_TYPE_SIMPLE, tok.Value)
+	case CBOR_TOKEN_FLOAT:
+		write_float(tw.w, tok.Float, &EncodeOptions{})
+	case CBOR_TOKEN_BREAK:
+		tw.w.WriteByte(0xFF)
+	default:
+		return fmt.Errorf("cbor: invalid %s", tok.Kind)
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (tw *TokenWriter) Flush() error {
+	return tw.w.Flush()
+}
@@ -0,0 +1,204 @@
+package cbor
+
+import "io"
+import "bytes"
+import "errors"
+import "testing"
+import "testing/iotest"
+
+// token_value builds the tree of the item that starts with tok, showing that
+// the tokens carry everything CBORDecode needs.
+func token_value(tz *Tokenizer, tok Token) (*CborValue, error) {
+	switch tok.Kind {
+	case CBOR_TOKEN_UINT, CBOR_TOKEN_NEGINT:
+		val := NewUint64(tok.Value)
+		if tok.Kind == CBOR_TOKEN_NEGINT {
+			val.ctype = CBOR_TYPE_NEGINT
+		}
+		return val, nil
+	case CBOR_TOKEN_BYTES, CBOR_TOKEN_TEXT:
+		blob := tok.Bytes
+		for tok.Indefinite {
+			chunk, err := tz.Next()
+			if err != nil {
+				return nil, err
+			}
+			if chunk.Kind == CBOR_TOKEN_BREAK {
+				break
+			}
+			blob = append(blob, chunk.Bytes...)
+		}
+		if tok.Kind == CBOR_TOKEN_TEXT {
+			return NewString(string(blob)), nil
+		}
+		return NewBytestring(blob), nil
+	case CBOR_TOKEN_START_ARRAY, CBOR_TOKEN_START_MAP:
+		val := NewArray()
+		if tok.Kind == CBOR_TOKEN_START_MAP {
+			val = NewMap()
+		}
+		for {
+			next, err := tz.Next()
+			if err != nil {
+				return nil, err
+			}
+			if next.Kind == CBOR_TOKEN_END || next.Kind == CBOR_TOKEN_BREAK {
+				return val, nil
+			}
+			if val.IsMap() {
+				if next.Kind != CBOR_TOKEN_KEY {
+					return nil, errors.New("expected key token, got " + next.Kind.String())
+				}
+				if next, err = tz.Next(); err != nil {
+					return nil, err
+				}
+			}
+			ele, err := token_value(tz, next)
+			if err != nil {
+				return nil, err
+			}
+			if val.IsMap() {
+				next, err := tz.Next()
+				if err != nil {
+					return nil, err
+				}
+				value, err := token_value(tz, next)
+				if err != nil {
+					return nil, err
+				}
+				ele = NewPair(ele, value)
+			}
+			val.ContainerInsertTail(ele)
+		}
+	case CBOR_TOKEN_TAG:
+		next, err := tz.Next()
+		if err != nil {
+			return nil, err
+		}
+		content, err := token_value(tz, next)
+		if err != nil {
+			return nil, err
+		}
+		return NewTagged(tok.Value, content), nil
+	case CBOR_TOKEN_SIMPLE:
+		val := NewUndef()
+		val.ctrl = int(tok.Value)
+		return val, nil
+	case CBOR_TOKEN_FLOAT:
+		return NewFloat(tok.Float), nil
+	}
+	return nil, errors.New("unexpected " + tok.Kind.String())
+}
+
+func TestTokenizer(t *testing.T) {
+	for idx, item := range content {
+		expect, _ := CBORDecode([]byte(item))
+		sources := []*Tokenizer{
+			NewTokenizer([]byte(item)),
+			NewTokenReader(iotest.OneByteReader(bytes.NewReader([]byte(item)))),
+		}
+		for _, tz := range sources {
+			tok, err := tz.Next()
+			if err != nil {
+				t.Errorf("%d. tokenize fail: %v", idx, err)
+				continue
+			}
+			val, err := token_value(tz, tok)
+			if err != nil {
+				t.Errorf("%d. tokenize fail: %v", idx, err)
+				continue
+			}
+			if !bytes.Equal(CBOREncode(val).Bytes(), CBOREncode(expect).Bytes()) {
+				t.Errorf("%d. tokens not equal: %#v", idx, []byte(item))
+			}
+			if _, err := tz.Next(); err != io.EOF {
+				t.Errorf("%d. expected io.EOF, got %v", idx, err)
+			}
+		}
+	}
+}
+
+func TestTokenizerEvents(t *testing.T) {
+	// {"a": [1, -2], "b": (_ h'01' h'02')} then 6(1.5)
+	data := []byte("\xa2\x61a\x82\x01\x21\x61b\x5f\x41\x01\x41\x02\xff\xc6\xf9\x3e\x00")
+	expect := []Token{
+		{Kind: CBOR_TOKEN_START_MAP, Offset: 0, Value: 2},
+		{Kind: CBOR_TOKEN_KEY, Offset: 1},
+		{Kind: CBOR_TOKEN_TEXT, Offset: 1, Value: 1, Bytes: []byte("a")},
+		{Kind: CBOR_TOKEN_START_ARRAY, Offset: 3, Value: 2},
+		{Kind: CBOR_TOKEN_UINT, Offset: 4, Value: 1},
+		{Kind: CBOR_TOKEN_NEGINT, Offset: 5, Value: 1},
+		{Kind: CBOR_TOKEN_END, Offset: 6},
+		{Kind: CBOR_TOKEN_KEY, Offset: 6},
+		{Kind: CBOR_TOKEN_TEXT, Offset: 6, Value: 1, Bytes: []byte("b")},
+		{Kind: CBOR_TOKEN_BYTES, Offset: 8, Indefinite: true},
+		{Kind: CBOR_TOKEN_BYTES, Offset: 9, Value: 1, Bytes: []byte{1}},
+		{Kind: CBOR_TOKEN_BYTES, Offset: 11, Value: 1, Bytes: []byte{2}},
+		{Kind: CBOR_TOKEN_BREAK, Offset: 13},
+		{Kind: CBOR_TOKEN_END, Offset: 14},
+		{Kind: CBOR_TOKEN_TAG, Offset: 14, Value: 6},
+		{Kind: CBOR_TOKEN_FLOAT, Offset: 15, Value: 0x3E00, Float: 1.5},
+	}
+
+	tz := NewTokenizer(data)
+	out := new(bytes.Buffer)
+	tw := NewTokenWriter(out)
+	for idx, want := range expect {
+		tok, err := tz.Next()
+		if err != nil || tok.Kind != want.Kind || tok.Offset != want.Offset || tok.Value != want.Value ||
+			tok.Float != want.Float || !bytes.Equal(tok.Bytes, want.Bytes) || tok.Indefinite != want.Indefinite {
+			t.Fatalf("%d. got %+v, %v, expected %+v", idx, tok, err, want)
+		}
+		if err := tw.WriteToken(tok); err != nil {
+			t.Fatalf("%d. write token fail: %v", idx, err)
+		}
+	}
+	if _, err := tz.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if err := tw.Flush(); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("token writer got %x, %v", out.Bytes(), err)
+	}
+}
+
+func TestTokenizerErrors(t *testing.T) {
+	cases := []struct {
+		data   string
+		offset int
+		kind   ErrorKind
+	}{
+		{"\x82\x01", 2, CBOR_ERR_TRUNCATED},
+		{"\x62a", 0, CBOR_ERR_TRUNCATED},
+		{"\x82\x01\xff", 2, CBOR_ERR_UNEXPECTED_BREAK},
+		{"\xbf\x01\xff", 2, CBOR_ERR_UNEXPECTED_BREAK},
+		{"\x5f\x61a\xff", 1, CBOR_ERR_INVALID_CHUNK},
+		{"\x7f\x7f\xff\xff", 1, CBOR_ERR_INVALID_CHUNK},
+		{"\x1f", 0, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+		{"\xf8\x01", 0, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+		{"\xf8\x1f", 0, CBOR_ERR_INVALID_ADDITIONAL_INFO},
+		{"\x61\xff", 0, CBOR_ERR_INVALID_UTF8},
+	}
+	for _, c := range cases {
+		tz := NewTokenizer([]byte(c.data))
+		var err error
+		for err == nil {
+			_, err = tz.Next()
+		}
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) || syntax.Offset != c.offset || syntax.Kind != c.kind {
+			t.Errorf("%#v: expected %s at %d, got %v", []byte(c.data), c.kind, c.offset, err)
+		}
+		if _, again := tz.Next(); again != err {
+			t.Errorf("%#v: error not sticky", []byte(c.data))
+		}
+	}
+
+	tz := NewTokenReader(bytes.NewReader([]byte("\x82\x01")))
+	var err error
+	for err == nil {
+		_, err = tz.Next()
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF from reader, got %v", err)
+	}
+}
@@ -0,0 +1,435 @@
+package cbor
+
+import "math"
+import "errors"
+import "reflect"
+import "encoding/binary"
+
+const (
+	CBOR_TAG_TYPED_ARRAY_MIN  uint64 = 64   // RFC 8746 typed arrays, 64 to 87
+	CBOR_TAG_TYPED_ARRAY_MAX  uint64 = 87
+	CBOR_TAG_MULTI_DIM        uint64 = 40   // [dimensions, elements], row-major
+	CBOR_TAG_MULTI_DIM_COLUMN uint64 = 1040 // [dimensions, elements], column-major
+)
+
+// multi-dimensional arrays with more dimensions are not converted
+const multi_dim_max = 64
+
+// ErrNotTypedArray is returned by TypedArray for an item that is not in a
+// typed array or multi-dimensional array tag.
+var ErrNotTypedArray = errors.New("cbor: not a typed array tag")
+
+var typed_uint_types = []reflect.Type{reflect.TypeOf(uint8(0)), reflect.TypeOf(uint16(0)), reflect.TypeOf(uint32(0)), reflect.TypeOf(uint64(0))}
+var typed_int_types = []reflect.Type{reflect.TypeOf(int8(0)), reflect.TypeOf(int16(0)), reflect.TypeOf(int32(0)), reflect.TypeOf(int64(0))}
+var typed_float_types = []reflect.Type{reflect.TypeOf(float32(0)), reflect.TypeOf(float32(0)), reflect.TypeOf(float64(0))}
+
+// typed_kind decodes the tag number of a typed array, 0b010_f_s_e_ll: the Go
+// type of its elements, their size in bytes and byte order. Half floats are
+// widened to float32, float128 and the reserved tag 76 are not supported.
+func typed_kind(number uint64) (reflect.Type, int, binary.ByteOrder, bool) {
+	if number < CBOR_TAG_TYPED_ARRAY_MIN || number > CBOR_TAG_TYPED_ARRAY_MAX {
+		return nil, 0, nil, false
+	}
+	bits := number - CBOR_TAG_TYPED_ARRAY_MIN
+	ll := int(bits & 3)
+	var order binary.ByteOrder = binary.BigEndian
+	if bits & 4 != 0 {
+		order = binary.LittleEndian
+	}
+	if bits & 16 != 0 {
+		if ll == 3 {
+			return nil, 0, nil, false
+		}
+		return typed_float_types[ll], 2 << ll, order, true
+	} else if bits & 8 != 0 {
+		if bits == 12 {
+			return nil, 0, nil, false
+		}
+		return typed_int_types[ll], 1 << ll, order, true
+	}
+	return typed_uint_types[ll], 1 << ll, order, true
+}
+
+// is_typed_tag reports whether number is a typed array or multi-dimensional
+// array tag.
+func is_typed_tag(number uint64) bool {
+	return (number >= CBOR_TAG_TYPED_ARRAY_MIN && number <= CBOR_TAG_TYPED_ARRAY_MAX) ||
+		number == CBOR_TAG_MULTI_DIM || number == CBOR_TAG_MULTI_DIM_COLUMN
+}
+
+// typed_slice converts the byte string of a typed array into a slice of its
+// element type.
+func (val *CborValue) typed_slice() (reflect.Value, error) {
+	typ, size, order, ok := typed_kind(val.num)
+	if !ok {
+		return reflect.Value{}, errors.New("typed array element type is not supported")
+	}
+	content := val.TagContent()
+	if content == nil || content.ctype != CBOR_TYPE_BYTESTRING {
+		return reflect.Value{}, errors.New("typed array is not a byte string")
+	}
+	b := content.blob
+	if len(b) % size != 0 {
+		return reflect.Value{}, errors.New("typed array length is not a multiple of its element size")
+	}
+	n := len(b) / size
+	switch typ.Kind() {
+	case reflect.Uint8:
+		return reflect.ValueOf(append([]uint8{}, b...)), nil
+	case reflect.Uint16:
+		s := make([]uint16, n)
+		for i := range s {
+			s[i] = order.Uint16(b[i*2:])
+		}
+		return reflect.ValueOf(s), nil
+	case reflect.Uint32:
+		s := make([]uint32, n)
+		for i := range s {
+			s[i] = order.Uint32(b[i*4:])
+		}
+		return reflect.ValueOf(s), nil
+	case reflect.Uint64:
+		s := make([]uint64, n)
+		for i := range s {
+			s[i] = order.Uint64(b[i*8:])
+		}
+		return reflect.ValueOf(s), nil
+	case reflect.Int8:
+		s := make([]int8, n)
+		for i := range s {
+			s[i] = int8(b[i])
+		}
+		return reflect.ValueOf(s), nil
+	case reflect.Int16:
+		s := make([]int16, n)
+		for i := range s {
+			s[i] = int16(order.Uint16(b[i*2:]))
+		}
+		return reflect.ValueOf(s), nil
+	case reflect.Int32:
+		s := make([]int32, n)
+		for i := range s {
+			s[i] = int32(order.Uint32(b[i*4:]))
+		}
+		return reflect.ValueOf(s), nil
+	case reflect.Int64:
+		s := make([]int64, n)
+		for i := range s {
+			s[i] = int64(order.Uint64(b[i*8:]))
+		}
+		return reflect.ValueOf(s), nil
+	case reflect.Float32:
+		s := make([]float32, n)
+		for i := range s {
+			if size == 2 {
+				s[i] = float32(float16_to_float64(order.Uint16(b[i*2:])))
+			} else {
+				s[i] = math.Float32frombits(order.Uint32(b[i*4:]))
+			}
+		}
+		return reflect.ValueOf(s), nil
+	}
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = math.Float64frombits(order.Uint64(b[i*8:]))
+	}
+	return reflect.ValueOf(s), nil
+}
+
+// multi_slice nests the elements of flat, in encoded order, into slices of
+// slices along dims starting at level.
+func multi_slice(flat reflect.Value, dims []int, strides []int, level int, offset int) reflect.Value {
+	t := flat.Type()
+	for i := level + 1; i < len(dims); i++ {
+		t = reflect.SliceOf(t)
+	}
+	s := reflect.MakeSlice(t, dims[level], dims[level])
+	for i := 0; i < dims[level]; i++ {
+		if level == len(dims) - 1 {
+			s.Index(i).Set(flat.Index(offset + i * strides[level]))
+		} else {
+			s.Index(i).Set(multi_slice(flat, dims, strides, level + 1, offset + i * strides[level]))
+		}
+	}
+	return s
+}
+
+// typed_value converts a typed array into a slice and a multi-dimensional
+// array into nested slices, leaf converts the elements of a
+// multi-dimensional array given as a plain array.
+func (val *CborValue) typed_value(leaf func(items []*CborValue) (reflect.Value, error)) (reflect.Value, error) {
+	if val.num != CBOR_TAG_MULTI_DIM && val.num != CBOR_TAG_MULTI_DIM_COLUMN {
+		return val.typed_slice()
+	}
+	content := val.TagContent()
+	if !content.IsArray() || content.ContainerSize() != 2 {
+		return reflect.Value{}, errors.New("multi-dimensional array is not [dimensions, elements]")
+	}
+	dimensions := content.ContainerAt(0)
+	elements := content.ContainerAt(1)
+	if !dimensions.IsArray() || dimensions.ContainerSize() == 0 || dimensions.ContainerSize() > multi_dim_max {
+		return reflect.Value{}, errors.New("multi-dimensional array has invalid dimensions")
+	}
+	var flat reflect.Value
+	var err error
+	if elements.IsTag() && elements.num >= CBOR_TAG_TYPED_ARRAY_MIN && elements.num <= CBOR_TAG_TYPED_ARRAY_MAX {
+		flat, err = elements.typed_slice()
+	} else if elements.IsArray() {
+		flat, err = leaf(elements.items)
+	} else {
+		err = errors.New("multi-dimensional array elements are not an array")
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	// no level may hold more slices than there are elements, or one when
+	// there are none, which bounds the memory an empty array takes
+	count := flat.Len()
+	limit := count
+	if limit == 0 {
+		limit = 1
+	}
+	dims := make([]int, dimensions.ContainerSize())
+	size := 1
+	for i, ele := range dimensions.items {
+		u, ok := ele.Uint64()
+		if !ok || u > math.MaxInt || (u != 0 && uint64(size) > uint64(limit) / u) {
+			return reflect.Value{}, errors.New("multi-dimensional array dimensions do not match its elements")
+		}
+		dims[i] = int(u)
+		size *= dims[i]
+	}
+	if size != count {
+		return reflect.Value{}, errors.New("multi-dimensional array dimensions do not match its elements")
+	}
+	strides := make([]int, len(dims))
+	stride := 1
+	for i := range dims {
+		j := len(dims) - 1 - i
+		if val.num == CBOR_TAG_MULTI_DIM_COLUMN {
+			j = i
+		}
+		strides[j] = stride
+		stride *= dims[j]
+	}
+	return multi_slice(flat, dims, strides, 0, 0), nil
+}
+
+// interface_leaf converts the elements of a multi-dimensional array into a
+// []interface{}.
+func interface_leaf(tags *TagSet) func(items []*CborValue) (reflect.Value, error) {
+	return func(items []*CborValue) (reflect.Value, error) {
+		flat := make([]interface{}, len(items))
+		for i, ele := range items {
+			item, err := value_interface(ele, tags)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			flat[i] = item
+		}
+		return reflect.ValueOf(flat), nil
+	}
+}
+
+// typed_plain returns a typed or multi-dimensional array as plain, nested
+// arrays of numbers, or nil when its content is invalid.
+func (val *CborValue) typed_plain() *CborValue {
+	v, err := val.typed_value(func(items []*CborValue) (reflect.Value, error) {
+		return reflect.ValueOf(items), nil
+	})
+	if err != nil {
+		return nil
+	}
+	plain, err := marshal_value(v, &EncodeOptions{}, 0)
+	if err != nil {
+		return nil
+	}
+	return plain
+}
+
+// TypedArray converts a typed array, tag 64 to 87, into a Go slice of its
+// element type, []uint8 to []uint64, []int8 to []int64, []float32 or
+// []float64, and a multi-dimensional array, tag 40 or 1040, into nested
+// slices indexed in row-major order. Half floats become float32.
+func (val *CborValue) TypedArray() (interface{}, error) {
+	if !val.IsTag() || !is_typed_tag(val.num) {
+		return nil, ErrNotTypedArray
+	}
+	v, err := val.typed_value(interface_leaf(nil))
+	if err != nil {
+		return nil, &TagError{Number: val.num, Err: err}
+	}
+	return v.Interface(), nil
+}
+
+// typed_number reports whether a typed array holds elements of kind.
+func typed_number(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// typed_array encodes a slice or array of numbers as a little-endian typed
+// array. Int, uint and uintptr take 64 bits.
+func typed_array(v reflect.Value) *CborValue {
+	kind := v.Type().Elem().Kind()
+	var number uint64
+	var size int
+	switch kind {
+	case reflect.Uint8:
+		number, size = 64, 1
+	case reflect.Uint16:
+		number, size = 69, 2
+	case reflect.Uint32:
+		number, size = 70, 4
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		number, size = 71, 8
+	case reflect.Int8:
+		number, size = 72, 1
+	case reflect.Int16:
+		number, size = 77, 2
+	case reflect.Int32:
+		number, size = 78, 4
+	case reflect.Int, reflect.Int64:
+		number, size = 79, 8
+	case reflect.Float32:
+		number, size = 85, 4
+	case reflect.Float64:
+		number, size = 86, 8
+	default:
+		return nil
+	}
+	b := make([]byte, v.Len() * size)
+	for i := 0; i < v.Len(); i++ {
+		ele := v.Index(i)
+		p := b[i*size:]
+		switch kind {
+		case reflect.Uint8:
+			p[0] = uint8(ele.Uint())
+		case reflect.Int8:
+			p[0] = uint8(ele.Int())
+		case reflect.Uint16:
+			binary.LittleEndian.PutUint16(p, uint16(ele.Uint()))
+		case reflect.Int16:
+			binary.LittleEndian.PutUint16(p, uint16(ele.Int()))
+		case reflect.Uint32:
+			binary.LittleEndian.PutUint32(p, uint32(ele.Uint()))
+		case reflect.Int32:
+			binary.LittleEndian.PutUint32(p, uint32(ele.Int()))
+		case reflect.Float32:
+			binary.LittleEndian.PutUint32(p, math.Float32bits(float32(ele.Float())))
+		case reflect.Float64:
+			binary.LittleEndian.PutUint64(p, math.Float64bits(ele.Float()))
+		case reflect.Int, reflect.Int64:
+			binary.LittleEndian.PutUint64(p, uint64(ele.Int()))
+		default:
+			binary.LittleEndian.PutUint64(p, ele.Uint())
+		}
+	}
+	val := NewBytestring(nil)
+	val.blob = b
+	return NewTagged(number, val)
+}
+
+// typed_dims returns the lengths of a rectangular slice or array of depth
+// levels, or false when the lengths differ or one is zero.
+func typed_dims(v reflect.Value, depth int) ([]int, bool) {
+	dims := make([]int, 0, depth)
+	for cur := v; len(dims) < depth; {
+		if cur.Len() == 0 {
+			return nil, false
+		}
+		dims = append(dims, cur.Len())
+		cur = cur.Index(0)
+	}
+	var check func(v reflect.Value, level int) bool
+	check = func(v reflect.Value, level int) bool {
+		if v.Len() != dims[level] {
+			return false
+		}
+		for i := 0; level < depth - 1 && i < v.Len(); i++ {
+			if !check(v.Index(i), level + 1) {
+				return false
+			}
+		}
+		return true
+	}
+	return dims, check(v, 0)
+}
+
+// typed_flatten copies the numbers of a rectangular slice or array into flat
+// at offset in row-major order, returning the offset that follows.
+func typed_flatten(flat reflect.Value, v reflect.Value, offset int) int {
+	if v.Type().Elem().Kind() != reflect.Slice && v.Type().Elem().Kind() != reflect.Array {
+		return offset + reflect.Copy(flat.Slice(offset, offset + v.Len()), v)
+	}
+	for i := 0; i < v.Len(); i++ {
+		offset = typed_flatten(flat, v.Index(i), offset)
+	}
+	return offset
+}
+
+// typed_matrix encodes a slice or array of numbers as a typed array, and a
+// rectangular slice or array of those as a row-major multi-dimensional
+// array, or returns nil for other values.
+func typed_matrix(v reflect.Value) *CborValue {
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil
+	}
+	depth := 0
+	t := v.Type()
+	for ; t.Kind() == reflect.Slice || t.Kind() == reflect.Array; t = t.Elem() {
+		depth++
+	}
+	if !typed_number(t.Kind()) {
+		return nil
+	}
+	if depth == 1 {
+		return typed_array(v)
+	}
+	dims, ok := typed_dims(v, depth)
+	if !ok {
+		return nil
+	}
+	size := 1
+	dimensions := NewArray()
+	for _, d := range dims {
+		size *= d
+		dimensions.ContainerInsertTail(NewInteger(int64(d)))
+	}
+	flat := reflect.MakeSlice(reflect.SliceOf(t), size, size)
+	typed_flatten(flat, v, 0)
+	content := NewArray()
+	content.ContainerInsertTail(dimensions)
+	content.ContainerInsertTail(typed_array(flat))
+	return NewTagged(CBOR_TAG_MULTI_DIM, content)
+}
+
+// NewTypedArray returns a slice or array of integers or floats as a
+// little-endian typed array, and a rectangular slice or array of those, a
+// matrix, as a row-major multi-dimensional array, tag 40. It returns nil
+// for any other value.
+func NewTypedArray(v interface{}) *CborValue {
+	return typed_matrix(reflect.ValueOf(v))
+}
+
+// marshal_typed encodes v as NewTypedArray does for EncodeOptions.TypedArrays,
+// except for bytes, which stay byte strings, and for numbers with their own
+// encoding, a marshal method or a registered tag.
+func marshal_typed(v reflect.Value, opts *EncodeOptions) *CborValue {
+	t := v.Type()
+	for t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	p := reflect.PtrTo(t)
+	if t.Kind() == reflect.Uint8 || opts.Tags.encoder(t) != nil || t == simple_type ||
+		p.Implements(marshaler_type) || p.Implements(binary_marshaler_type) || p.Implements(text_marshaler_type) {
+		return nil
+	}
+	return typed_matrix(v)
+}
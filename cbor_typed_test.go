@@ -0,0 +1,178 @@
+package cbor
+
+import "bytes"
+import "errors"
+import "reflect"
+import "testing"
+
+type typed_level int
+
+func (l typed_level) MarshalText() ([]byte, error) {
+	return []byte{'L', byte('0' + l)}, nil
+}
+
+func TestTypedArray(t *testing.T) {
+	cases := []struct {
+		data   string
+		expect interface{}
+	}{
+		{"\xd8\x40\x43\x01\x02\xff", []uint8{1, 2, 255}},
+		{"\xd8\x44\x41\x07", []uint8{7}},
+		{"\xd8\x41\x44\x00\x01\x01\x00", []uint16{1, 256}},
+		{"\xd8\x45\x44\x00\x01\x01\x00", []uint16{256, 1}},
+		{"\xd8\x42\x44\x00\x00\x00\x2a", []uint32{42}},
+		{"\xd8\x47\x48\x2a\x00\x00\x00\x00\x00\x00\x80", []uint64{0x800000000000002a}},
+		{"\xd8\x48\x42\xff\x80", []int8{-1, -128}},
+		{"\xd8\x49\x42\xff\xfe", []int16{-2}},
+		{"\xd8\x4e\x44\xfe\xff\xff\xff", []int32{-2}},
+		{"\xd8\x4b\x48\xff\xff\xff\xff\xff\xff\xff\xfd", []int64{-3}},
+		{"\xd8\x50\x44\x3c\x00\xc0\x00", []float32{1, -2}},
+		{"\xd8\x51\x44\x3f\xc0\x00\x00", []float32{1.5}},
+		{"\xd8\x56\x48\x00\x00\x00\x00\x00\x00\xf8\x3f", []float64{1.5}},
+		{"\xd8\x41\x40", []uint16{}},
+	}
+	for _, c := range cases {
+		val, err := CBORDecode([]byte(c.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := val.TypedArray(); err != nil || !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("%x: typed array got %#v, %v", c.data, got, err)
+		}
+		var any interface{}
+		if err := Unmarshal([]byte(c.data), &any); err != nil || !reflect.DeepEqual(any, c.expect) {
+			t.Errorf("%x: unmarshal into interface got %#v, %v", c.data, any, err)
+		}
+		target := reflect.New(reflect.TypeOf(c.expect))
+		if err := Unmarshal([]byte(c.data), target.Interface()); err != nil || !reflect.DeepEqual(target.Elem().Interface(), c.expect) {
+			t.Errorf("%x: unmarshal into slice got %#v, %v", c.data, target.Elem().Interface(), err)
+		}
+	}
+
+	// other targets take the numbers as a plain array would
+	var wide []float64
+	if err := Unmarshal([]byte(cases[2].data), &wide); err != nil || !reflect.DeepEqual(wide, []float64{1, 256}) {
+		t.Errorf("unmarshal into wider slice got %v, %v", wide, err)
+	}
+	var fixed [3]int
+	if err := Unmarshal([]byte(cases[2].data), &fixed); err != nil || fixed != [3]int{1, 256, 0} {
+		t.Errorf("unmarshal into array got %v, %v", fixed, err)
+	}
+	var narrow []uint8
+	var typeerr *UnmarshalTypeError
+	if err := Unmarshal([]byte(cases[2].data), &narrow); !errors.As(err, &typeerr) || typeerr.Offset != 0 {
+		t.Errorf("expected type error for overflow, got %v", err)
+	}
+	var nested struct{ V []uint8 }
+	if err := Unmarshal([]byte("\xa1\x61V\xd8\x41\x42\x01\x00"), &nested); !errors.As(err, &typeerr) || typeerr.Offset != 3 {
+		t.Errorf("expected type error at 3, got %v", err)
+	}
+
+	var tagerr *TagError
+	for _, bad := range []string{"\xd8\x53\x50" + string(make([]byte, 16)), "\xd8\x4c\x41\x00", "\xd8\x42\x43\x00\x00\x00", "\xd8\x41\x02"} {
+		val, _ := CBORDecode([]byte(bad))
+		if _, err := val.TypedArray(); !errors.As(err, &tagerr) {
+			t.Errorf("%x: expected tag error, got %v", bad, err)
+		}
+		var any interface{}
+		if err := Unmarshal([]byte(bad), &any); err != nil || reflect.TypeOf(any) != tag_type {
+			t.Errorf("%x: unsupported typed array got %#v, %v", bad, any, err)
+		}
+		var s []uint64
+		if err := Unmarshal([]byte(bad), &s); !errors.As(err, &tagerr) {
+			t.Errorf("%x: expected tag error from unmarshal, got %v", bad, err)
+		}
+	}
+	if _, err := NewString("x").TypedArray(); err != ErrNotTypedArray {
+		t.Errorf("expected ErrNotTypedArray, got %v", err)
+	}
+	if s := JSONEncode(NewTypedArray([]int16{-1, 2})).String(); s != "[-1, 2]" {
+		t.Errorf("json typed array got %s", s)
+	}
+}
+
+func TestTypedArrayMarshal(t *testing.T) {
+	opts := EncodeOptions{TypedArrays: true}
+	cases := []struct {
+		value interface{}
+		data  string
+	}{
+		{[]float32{1.5, -2}, "\xd8\x55\x48\x00\x00\xc0\x3f\x00\x00\x00\xc0"},
+		{[2]uint16{1, 256}, "\xd8\x45\x44\x01\x00\x00\x01"},
+		{[]int{-1}, "\xd8\x4f\x48\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{[]int8{}, "\xd8\x48\x40"},
+		{[]byte{1, 2}, "\x42\x01\x02"},
+		{[][]byte{{1}, {2}}, "\x82\x41\x01\x41\x02"},
+		{[]typed_level{1}, "\x81\x62L1"},
+		{[]interface{}{1}, "\x81\x01"},
+		{[][]float64{{1}, {}}, "\x82\xd8\x56\x48\x00\x00\x00\x00\x00\x00\xf0\x3f\xd8\x56\x40"},
+		{[][]int16{{1, 2, 3}, {4, 5, 6}}, "\xd8\x28\x82\x82\x02\x03\xd8\x4d\x4c\x01\x00\x02\x00\x03\x00\x04\x00\x05\x00\x06\x00"},
+		{[2][1][1]uint32{{{7}}, {{8}}}, "\xd8\x28\x82\x83\x02\x01\x01\xd8\x46\x48\x07\x00\x00\x00\x08\x00\x00\x00"},
+	}
+	for _, c := range cases {
+		if data, err := opts.Marshal(c.value); err != nil || !bytes.Equal(data, []byte(c.data)) {
+			t.Errorf("%#v: marshal got %x, %v", c.value, data, err)
+		}
+	}
+	if data, _ := Marshal([]float32{1.5}); !bytes.Equal(data, []byte("\x81\xf9\x3e\x00")) {
+		t.Errorf("marshal without typed arrays got %x", data)
+	}
+	if NewTypedArray([]string{"a"}) != nil || NewTypedArray(1) != nil || NewTypedArray([]byte{1}).TagNumber() != 64 {
+		t.Errorf("new typed array types fail")
+	}
+}
+
+func TestMultiDimArray(t *testing.T) {
+	matrix := [][]int16{{1, 2, 3}, {4, 5, 6}}
+	data, err := EncodeOptions{TypedArrays: true}.Marshal(matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var any interface{}
+	if err := Unmarshal(data, &any); err != nil || !reflect.DeepEqual(any, matrix) {
+		t.Errorf("unmarshal matrix into interface got %#v, %v", any, err)
+	}
+	var rows [][]int16
+	if err := Unmarshal(data, &rows); err != nil || !reflect.DeepEqual(rows, matrix) {
+		t.Errorf("unmarshal matrix got %v, %v", rows, err)
+	}
+	var grid [2][3]float64
+	if err := Unmarshal(data, &grid); err != nil || grid != [2][3]float64{{1, 2, 3}, {4, 5, 6}} {
+		t.Errorf("unmarshal matrix into array got %v, %v", grid, err)
+	}
+	val, _ := CBORDecode(data)
+	if s := JSONEncode(val).String(); s != "[[1, 2, 3], [4, 5, 6]]" {
+		t.Errorf("json matrix got %s", s)
+	}
+
+	// column-major with plain elements, 1040([[2, 3], [1, 4, 2, 5, 3, 6]])
+	column := []byte("\xd9\x04\x10\x82\x82\x02\x03\x86\x01\x04\x02\x05\x03\x06")
+	var ints [][]int
+	if err := Unmarshal(column, &ints); err != nil || !reflect.DeepEqual(ints, [][]int{{1, 2, 3}, {4, 5, 6}}) {
+		t.Errorf("unmarshal column-major got %v, %v", ints, err)
+	}
+	val, _ = CBORDecode(column)
+	if got, err := val.TypedArray(); err != nil || !reflect.DeepEqual(got, [][]interface{}{{uint64(1), uint64(2), uint64(3)}, {uint64(4), uint64(5), uint64(6)}}) {
+		t.Errorf("column-major typed array got %#v, %v", got, err)
+	}
+
+	// 40([[0, 5], []]) is empty, an empty inner dimension is refused
+	empty, _ := CBORDecode([]byte("\xd8\x28\x82\x82\x00\x05\x80"))
+	if got, err := empty.TypedArray(); err != nil || reflect.ValueOf(got).Len() != 0 {
+		t.Errorf("empty matrix got %#v, %v", got, err)
+	}
+	var tagerr *TagError
+	for _, bad := range []string{
+		"\xd8\x28\x82\x82\x1a\x3b\x9a\xca\x00\x00\x80", // [1000000000, 0]
+		"\xd8\x28\x82\x82\x02\x02\x83\x01\x02\x03",     // 3 elements for 2x2
+		"\xd8\x28\x82\x83\x00\x1b\x80\x00\x00\x00\x00\x00\x00\x00\x02\x80", // [0, 2^63, 2]
+		"\xd8\x28\x82\x80\x80",                         // no dimensions
+		"\xd8\x28\x82\x81\x01\x01",                     // elements not an array
+		"\xd8\x28\x81\x80",
+	} {
+		val, _ := CBORDecode([]byte(bad))
+		if _, err := val.TypedArray(); !errors.As(err, &tagerr) {
+			t.Errorf("%x: expected tag error, got %v", bad, err)
+		}
+	}
+}
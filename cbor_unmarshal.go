@@ -0,0 +1,620 @@
+package cbor
+
+import "math"
+import "errors"
+import "reflect"
+import "encoding"
+import "strings"
+
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "cbor: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "cbor: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "cbor: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// UnmarshalTypeError describes a data item that cannot be stored in a Go
+// value of the given type.
+type UnmarshalTypeError struct {
+	Value  string
+	Type   reflect.Type
+	Offset int
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return "cbor: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+}
+
+var type_names = map[int]string{
+	CBOR_TYPE_UINT:       "unsigned integer",
+	CBOR_TYPE_NEGINT:     "negative integer",
+	CBOR_TYPE_BYTESTRING: "byte string",
+	CBOR_TYPE_STRING:     "text string",
+	CBOR_TYPE_ARRAY:      "array",
+	CBOR_TYPE_MAP:        "map",
+	CBOR_TYPE_TAG:        "tag",
+	CBOR_TYPE_SIMPLE:     "simple value",
+}
+
+// unmarshal_state walks the encoded bytes of an item that cbor_skip has
+// already checked, so only type mismatches can fail here.
+type unmarshal_state struct {
+	data []byte
+	opts *DecodeOptions
+}
+
+func (d *unmarshal_state) type_error(head cbor_head, offset int, t reflect.Type) error {
+	name := type_names[head.ctype]
+	if head.ctype == CBOR_TYPE_SIMPLE {
+		if head.addition == 20 || head.addition == 21 {
+			name = "boolean"
+		} else if head.addition >= 25 && head.addition <= 27 {
+			name = "float"
+		}
+	}
+	return &UnmarshalTypeError{Value: name, Type: t, Offset: offset}
+}
+
+func (d *unmarshal_state) skip(offset int) int {
+	consume, _ := cbor_skip(d.data, offset, &DecodeOptions{}, 0)
+	return offset + consume
+}
+
+// read_string returns the content of the string at offset, joining the
+// chunks of an indefinite-length string.
+func (d *unmarshal_state) read_string(head cbor_head, offset int) ([]byte, int) {
+	_, _, consume := read_head(d.data, offset)
+	offset += consume
+	if head.addition != 31 {
+		size := int(head.argument)
+		return d.data[offset:offset+size], offset + size
+	}
+	var blob []byte
+	for d.data[offset] != 0xFF {
+		chunk, _, consume := read_head(d.data, offset)
+		offset += consume
+		blob = append(blob, d.data[offset:offset+int(chunk.argument)]...)
+		offset += int(chunk.argument)
+	}
+	return blob, offset + 1
+}
+
+// entries calls fn with the offset of each element of the array or map at
+// offset, fn returns the offset following the element, or the pair for maps.
+func (d *unmarshal_state) entries(head cbor_head, offset int, fn func(i int, offset int) (int, error)) (int, error) {
+	_, _, consume := read_head(d.data, offset)
+	offset += consume
+	for i := 0; head.addition == 31 || uint64(i) < head.argument; i++ {
+		if head.addition == 31 && d.data[offset] == 0xFF {
+			return offset + 1, nil
+		}
+		next, err := fn(i, offset)
+		if err != nil {
+			return 0, err
+		}
+		offset = next
+	}
+	return offset, nil
+}
+
+func (d *unmarshal_state) value(offset int, v reflect.Value) (int, error) {
+	head, _, consume := read_head(d.data, offset)
+	is_null := head.ctype == CBOR_TYPE_SIMPLE && (head.addition == 22 || head.addition == 23)
+
+	// allocate pointers down to the value, a null clears the first one met
+	for {
+		if v.Type() == cbor_value_type {
+			val, err, consume := cbor_parse(d.data, offset, d.opts, 0)
+			if err != nil {
+				return 0, err
+			}
+			v.Set(reflect.ValueOf(val))
+			return offset + consume, nil
+		}
+		if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+			val, err, consume := cbor_parse(d.data, offset, d.opts, 0)
+			if err != nil {
+				return 0, err
+			}
+			iface, err := value_interface(val, d.opts.Tags)
+			if err != nil {
+				return 0, err
+			}
+			if iface == nil {
+				v.Set(reflect.Zero(v.Type()))
+			} else {
+				v.Set(reflect.ValueOf(iface))
+			}
+			return offset + consume, nil
+		}
+		if v.Kind() != reflect.Ptr {
+			break
+		}
+		if is_null {
+			v.Set(reflect.Zero(v.Type()))
+			return offset + consume, nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if head.ctype == CBOR_TYPE_TAG {
+		if entry := d.opts.Tags.decoder(head.argument); entry != nil && entry.typ == v.Type() {
+			val, err, consume := cbor_parse(d.data, offset, d.opts, 0)
+			if err != nil {
+				return 0, err
+			}
+			v.Set(reflect.ValueOf(val.converted))
+			return offset + consume, nil
+		}
+	}
+	if v.Type() == time_type && !is_null {
+		return d.time_value(head, offset, v)
+	}
+	if (v.Type() == big_int_type || v.Type() == big_float_type || v.Type() == big_rat_type) && !is_null {
+		return d.big_value(head, offset, v)
+	}
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(Unmarshaler); ok {
+			next := d.skip(offset)
+			return next, u.UnmarshalCBOR(d.data[offset:next])
+		}
+	}
+	if is_null {
+		v.Set(reflect.Zero(v.Type()))
+		return offset + consume, nil
+	}
+	if v.CanAddr() {
+		if next, ok, err := d.unmarshal_method(head, offset, v.Addr()); ok {
+			return next, err
+		}
+	}
+
+	switch head.ctype {
+	case CBOR_TYPE_UINT, CBOR_TYPE_NEGINT:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if head.argument > math.MaxInt64 {
+				return 0, d.type_error(head, offset, v.Type())
+			}
+			i := int64(head.argument)
+			if head.ctype == CBOR_TYPE_NEGINT {
+				i = -1 - i
+			}
+			if v.OverflowInt(i) {
+				return 0, d.type_error(head, offset, v.Type())
+			}
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if head.ctype == CBOR_TYPE_NEGINT || v.OverflowUint(head.argument) {
+				return 0, d.type_error(head, offset, v.Type())
+			}
+			v.SetUint(head.argument)
+		case reflect.Float32, reflect.Float64:
+			f := float64(head.argument)
+			if head.ctype == CBOR_TYPE_NEGINT {
+				f = -1 - f
+			}
+			v.SetFloat(f)
+		default:
+			return 0, d.type_error(head, offset, v.Type())
+		}
+		return offset + consume, nil
+	case CBOR_TYPE_BYTESTRING, CBOR_TYPE_STRING:
+		blob, next := d.read_string(head, offset)
+		if v.Kind() == reflect.String {
+			v.SetString(string(blob))
+		} else if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, len(blob))
+			copy(b, blob)
+			v.SetBytes(b)
+		} else if v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8 {
+			reflect.Copy(v, reflect.ValueOf(blob))
+			for i := len(blob); i < v.Len(); i++ {
+				v.Index(i).SetUint(0)
+			}
+		} else {
+			return 0, d.type_error(head, offset, v.Type())
+		}
+		return next, nil
+	case CBOR_TYPE_ARRAY:
+		if v.Kind() == reflect.Slice {
+			v.SetLen(0)
+			return d.entries(head, offset, func(i int, offset int) (int, error) {
+				if i >= v.Cap() {
+					grown := reflect.MakeSlice(v.Type(), v.Len(), v.Cap() * 2 + 4)
+					reflect.Copy(grown, v)
+					v.Set(grown)
+				}
+				v.SetLen(i + 1)
+				return d.value(offset, v.Index(i))
+			})
+		} else if v.Kind() == reflect.Array {
+			count := 0
+			next, err := d.entries(head, offset, func(i int, offset int) (int, error) {
+				count++
+				if i >= v.Len() {
+					return d.skip(offset), nil
+				}
+				return d.value(offset, v.Index(i))
+			})
+			for i := count; i < v.Len(); i++ {
+				v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+			}
+			return next, err
+		} else if v.Kind() == reflect.Struct && struct_toarray(v.Type()) {
+			fields := struct_fields(v.Type())
+			return d.entries(head, offset, func(i int, offset int) (int, error) {
+				if i >= len(fields) {
+					return d.skip(offset), nil
+				}
+				fv := field_by_index(v, fields[i].index, true)
+				if !fv.IsValid() {
+					return d.skip(offset), nil
+				}
+				return d.value(offset, fv)
+			})
+		}
+		return 0, d.type_error(head, offset, v.Type())
+	case CBOR_TYPE_MAP:
+		if v.Kind() == reflect.Map {
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			return d.entries(head, offset, func(i int, offset int) (int, error) {
+				key := reflect.New(v.Type().Key()).Elem()
+				offset, err := d.value(offset, key)
+				if err != nil {
+					return 0, err
+				}
+				ele := reflect.New(v.Type().Elem()).Elem()
+				offset, err = d.value(offset, ele)
+				if err != nil {
+					return 0, err
+				}
+				v.SetMapIndex(key, ele)
+				return offset, nil
+			})
+		} else if v.Kind() == reflect.Struct {
+			fields := struct_fields(v.Type())
+			return d.entries(head, offset, func(i int, offset int) (int, error) {
+				key, _, consume := read_head(d.data, offset)
+				var f *field_info
+				next := offset + consume
+				if key.ctype == CBOR_TYPE_STRING {
+					var name []byte
+					name, next = d.read_string(key, offset)
+					f = find_field(fields, string(name))
+				} else if key.ctype == CBOR_TYPE_UINT || key.ctype == CBOR_TYPE_NEGINT {
+					f = find_int_field(fields, key)
+				} else {
+					return d.skip(d.skip(offset)), nil
+				}
+				if f == nil {
+					return d.skip(next), nil
+				}
+				fv := field_by_index(v, f.index, true)
+				if !fv.IsValid() {
+					// behind a nil pointer to an unexported struct
+					return d.skip(next), nil
+				}
+				return d.value(next, fv)
+			})
+		}
+		return 0, d.type_error(head, offset, v.Type())
+	case CBOR_TYPE_TAG:
+		if is_typed_tag(head.argument) && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) {
+			return d.typed_value(offset, v)
+		}
+		// the tag number carries no meaning for a plain Go value
+		return d.value(offset + consume, v)
+	case CBOR_TYPE_SIMPLE:
+		if head.addition == 20 || head.addition == 21 {
+			if v.Kind() != reflect.Bool {
+				return 0, d.type_error(head, offset, v.Type())
+			}
+			v.SetBool(head.addition == 21)
+		} else if head.addition >= 25 && head.addition <= 27 {
+			if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+				return 0, d.type_error(head, offset, v.Type())
+			}
+			if head.addition == 25 {
+				v.SetFloat(float16_to_float64(uint16(head.argument)))
+			} else if head.addition == 26 {
+				v.SetFloat(float32_to_float64(uint32(head.argument)))
+			} else {
+				v.SetFloat(math.Float64frombits(head.argument))
+			}
+		} else if v.Type() == simple_type {
+			v.SetUint(head.argument)
+		} else {
+			return 0, d.type_error(head, offset, v.Type())
+		}
+		return offset + consume, nil
+	}
+	return 0, d.type_error(head, offset, v.Type())
+}
+
+// time_value decodes an item in a date or time tag into a time.Time, or an
+// untagged RFC 3339 string or epoch number as tags 0 and 1 would hold them.
+func (d *unmarshal_state) time_value(head cbor_head, offset int, v reflect.Value) (int, error) {
+	val, err, consume := cbor_parse(d.data, offset, d.opts, 0)
+	if err != nil {
+		return 0, err
+	}
+	if head.ctype == CBOR_TYPE_STRING {
+		val = NewTagged(CBOR_TAG_DATETIME, val)
+	} else if head.ctype == CBOR_TYPE_UINT || head.ctype == CBOR_TYPE_NEGINT || val.IsFloat() {
+		val = NewTagged(CBOR_TAG_EPOCH, val)
+	} else if head.ctype != CBOR_TYPE_TAG || !is_time_tag(head.argument) {
+		return 0, d.type_error(head, offset, v.Type())
+	}
+	t, err := val.Time()
+	if err != nil {
+		var te *TagError
+		if errors.As(err, &te) {
+			te.Offset = offset
+		}
+		return 0, err
+	}
+	v.Set(reflect.ValueOf(t))
+	return offset + consume, nil
+}
+
+// big_value decodes a number into a big.Int, big.Float or big.Rat, as
+// BigInt, BigFloat and BigRat convert it.
+func (d *unmarshal_state) big_value(head cbor_head, offset int, v reflect.Value) (int, error) {
+	val, err, consume := cbor_parse(d.data, offset, d.opts, 0)
+	if err != nil {
+		return 0, err
+	}
+	var number interface{} = nil
+	switch v.Type() {
+	case big_int_type:
+		if i := val.BigInt(); i != nil {
+			number = i
+		}
+	case big_float_type:
+		if f := val.BigFloat(); f != nil {
+			number = f
+		}
+	case big_rat_type:
+		if r := val.BigRat(); r != nil {
+			number = r
+		}
+	}
+	if number == nil {
+		return 0, d.type_error(head, offset, v.Type())
+	}
+	v.Set(reflect.ValueOf(number).Elem())
+	return offset + consume, nil
+}
+
+// typed_value decodes a typed or multi-dimensional array into a slice or
+// array. A slice of the element type takes the numbers as they are, other
+// targets receive them as plain arrays.
+func (d *unmarshal_state) typed_value(offset int, v reflect.Value) (int, error) {
+	val, err, consume := cbor_parse(d.data, offset, d.opts, 0)
+	if err != nil {
+		return 0, err
+	}
+	array, err := val.typed_value(interface_leaf(d.opts.Tags))
+	if err != nil {
+		return 0, &TagError{Offset: offset, Number: val.num, Err: err}
+	}
+	if array.Type().AssignableTo(v.Type()) {
+		v.Set(array)
+		return offset + consume, nil
+	}
+	plain, err := marshal_value(array, &EncodeOptions{}, 0)
+	if err != nil {
+		return 0, err
+	}
+	sub := &unmarshal_state{data: CBOREncode(plain).Bytes(), opts: d.opts}
+	if _, err := sub.value(0, v); err != nil {
+		var typeerr *UnmarshalTypeError
+		if errors.As(err, &typeerr) {
+			typeerr.Offset = offset
+		}
+		return 0, err
+	}
+	return offset + consume, nil
+}
+
+// unmarshal_method decodes a byte string with UnmarshalBinary or a text
+// string with UnmarshalText, reporting false when p implements neither.
+func (d *unmarshal_state) unmarshal_method(head cbor_head, offset int, p reflect.Value) (int, bool, error) {
+	bu, is_binary := p.Interface().(encoding.BinaryUnmarshaler)
+	tu, is_text := p.Interface().(encoding.TextUnmarshaler)
+	if !is_binary && !is_text {
+		return 0, false, nil
+	}
+	for head.ctype == CBOR_TYPE_TAG {
+		_, _, consume := read_head(d.data, offset)
+		offset += consume
+		head, _, _ = read_head(d.data, offset)
+	}
+	if is_binary && head.ctype == CBOR_TYPE_BYTESTRING {
+		blob, next := d.read_string(head, offset)
+		return next, true, bu.UnmarshalBinary(blob)
+	} else if is_text && head.ctype == CBOR_TYPE_STRING {
+		blob, next := d.read_string(head, offset)
+		return next, true, tu.UnmarshalText(blob)
+	}
+	return 0, true, d.type_error(head, offset, p.Type().Elem())
+}
+
+func find_field(fields []field_info, name string) *field_info {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// find_int_field returns the keyasint field whose key is the integer in head.
+func find_int_field(fields []field_info, head cbor_head) *field_info {
+	if head.argument > math.MaxInt64 {
+		return nil
+	}
+	i := int64(head.argument)
+	if head.ctype == CBOR_TYPE_NEGINT {
+		i = -1 - i
+	}
+	for j := range fields {
+		if fields[j].keyasint && fields[j].key_int == i {
+			return &fields[j]
+		}
+	}
+	return nil
+}
+
+// hashable reports whether v can be a map key. A comparable type is not
+// enough, a Tag holding a slice in its Content panics when hashed.
+func hashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	case reflect.Interface:
+		return v.IsNil() || hashable(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// value_interface converts a decoded item into the Go value an interface{}
+// receives: uint64, int64, float64, bool, nil, string, []byte,
+// []interface{}, map[interface{}]interface{}, Tag or SimpleValue, time.Time
+// for the date and time tags, *big.Int, *big.Float or *big.Rat for the big
+// number tags and for negative integers below the range of int64, the slices
+// TypedArray returns for typed arrays, or the type tags registers for a tag.
+func value_interface(val *CborValue, tags *TagSet) (interface{}, error) {
+	switch val.ctype {
+	case CBOR_TYPE_UINT:
+		return val.num, nil
+	case CBOR_TYPE_NEGINT:
+		if i, err := val.Int64(); err == nil {
+			return i, nil
+		}
+		return val.BigInt(), nil
+	case CBOR_TYPE_BYTESTRING:
+		return append([]byte{}, val.StringBytes()...), nil
+	case CBOR_TYPE_STRING:
+		return val.String(), nil
+	case CBOR_TYPE_ARRAY:
+		array := make([]interface{}, 0, val.ContainerSize())
+		for ele := val.ContainerFirst(); ele != nil; ele = val.ContainerNext(ele) {
+			item, err := value_interface(ele, tags)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, item)
+		}
+		return array, nil
+	case CBOR_TYPE_MAP:
+		m := make(map[interface{}]interface{}, val.ContainerSize())
+		for ele := val.ContainerFirst(); ele != nil; ele = val.ContainerNext(ele) {
+			key, err := value_interface(ele.PairKey(), tags)
+			if err != nil {
+				return nil, err
+			}
+			if key != nil && !hashable(reflect.ValueOf(key)) {
+				return nil, &UnsupportedTypeError{reflect.TypeOf(key)}
+			}
+			item, err := value_interface(ele.PairValue(), tags)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = item
+		}
+		return m, nil
+	case CBOR_TYPE_TAG:
+		if entry := tags.decoder(val.num); entry != nil {
+			if val.converted != nil && reflect.TypeOf(val.converted).AssignableTo(entry.typ) {
+				return val.converted, nil
+			}
+			converted, err := entry.convert(val.TagContent(), 0)
+			if err != nil {
+				return nil, err
+			}
+			return converted.Interface(), nil
+		}
+		if is_time_tag(val.num) {
+			if t, err := val.Time(); err == nil {
+				return t, nil
+			}
+		} else if is_big_tag(val.num) {
+			if number := val.big_interface(); number != nil {
+				return number, nil
+			}
+		} else if is_typed_tag(val.num) {
+			if array, err := val.typed_value(interface_leaf(tags)); err == nil {
+				return array.Interface(), nil
+			}
+		}
+		content, err := value_interface(val.TagContent(), tags)
+		if err != nil {
+			return nil, err
+		}
+		return Tag{Number: val.num, Content: content}, nil
+	case CBOR_TYPE_SIMPLE:
+		if val.ctrl == CBOR_SIMPLE_FALSE || val.ctrl == CBOR_SIMPLE_TRUE {
+			return val.Boolean(), nil
+		} else if val.ctrl == CBOR_SIMPLE_REAL {
+			return val.Float(), nil
+		} else if val.ctrl == CBOR_SIMPLE_NULL || val.ctrl == CBOR_SIMPLE_UNDEF {
+			return nil, nil
+		}
+		return SimpleValue(val.ctrl), nil
+	}
+	return nil, nil
+}
+
+func (opts DecodeOptions) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	if err := opts.Valid(data); err != nil {
+		return err
+	}
+	d := &unmarshal_state{data: data, opts: &opts}
+	_, err := d.value(0, rv.Elem())
+	return err
+}
+
+// Unmarshal decodes data, which must hold exactly one data item, into the
+// value v points to, allocating maps, slices and pointers as needed. Values
+// implementing Unmarshaler receive the encoded item, those implementing
+// encoding.BinaryUnmarshaler or encoding.TextUnmarshaler receive the content
+// of a byte or text string. A time.Time accepts the date and time tags, an
+// RFC 3339 string or an epoch number. Slices and arrays accept typed arrays
+// and multi-dimensional arrays as well as plain arrays.
+func Unmarshal(data []byte, v interface{}) error {
+	return DecodeOptions{}.Unmarshal(data, v)
+}
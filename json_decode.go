@@ -3,6 +3,7 @@ package cbor
 import "fmt"
 import "bytes"
 import "strconv"
+import "math/big"
 import "unicode"
 import "io/ioutil"
 
@@ -324,13 +325,12 @@ func (lexer *json_lexer) parse_number() (*CborValue, error) {
 	integer, err := strconv.ParseInt(buf.String(), 10, 64)
 	if err == nil {
 		return NewInteger(integer), nil
-	} else {
-		number, err := strconv.ParseFloat(buf.String(), 10)
-		if err == nil {
-			return NewFloat(number), nil
-		}
-		return nil, err
+	} else if bignum, ok := new(big.Int).SetString(buf.String(), 10); ok {
+		return NewBigInt(bignum), nil
+	} else if number, ok := json_decimal(buf.String()); ok {
+		return number, nil
 	}
+	return nil, fmt.Errorf("%d:%d invalid number `%s`", lexer.lineno, lexer.lineoff, buf.String())
 }
 
 func (lexer *json_lexer) parse() (*CborValue, error) {
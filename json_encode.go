@@ -11,13 +11,34 @@ func json_dump_indent(buf *bytes.Buffer) {
 }
 
 func json_dumps(buf *bytes.Buffer, val *CborValue) {
-	if val.ctype == CBOR_TYPE_MAP {
+	if val.ctype == CBOR__TYPE_RAW {
+		if raw, err := CBORDecode(val.blob); err == nil {
+			json_dumps(buf, raw)
+		}
+	} else if val.ctype == CBOR_TYPE_TAG {
+		if val.TagContent() == nil {
+			buf.WriteString("null")
+			return
+		}
+		if number, ok := json_number(val); ok {
+			buf.WriteString(number)
+			return
+		}
+		if is_typed_tag(val.num) {
+			if plain := val.typed_plain(); plain != nil {
+				json_dumps(buf, plain)
+				return
+			}
+		}
+		// the tag number has no JSON form, the content stands for the item
+		json_dumps(buf, val.TagContent())
+	} else if val.ctype == CBOR_TYPE_MAP {
 		buf.WriteByte('{')
 		for ele := val.ContainerFirst(); ele != nil; ele = val.ContainerNext(ele) {
-			json_dumps(buf, ele.key)
+			json_dumps(buf, ele.PairKey())
 			buf.WriteByte(':')
 			json_dump_indent(buf)
-			json_dumps(buf, ele.value)
+			json_dumps(buf, ele.PairValue())
 			if val.ContainerNext(ele) != nil {
 				buf.WriteByte(',')
 				json_dump_indent(buf)
@@ -45,7 +66,8 @@ func json_dumps(buf *bytes.Buffer, val *CborValue) {
 			buf.WriteString(strconv.FormatFloat(val.Float(), 'f', 6, 64))
 		}
 	} else if (val.ctype == CBOR_TYPE_UINT || val.ctype == CBOR_TYPE_NEGINT) {
-		buf.WriteString(strconv.FormatInt(val.Integer(), 10))
+		number, _ := json_number(val)
+		buf.WriteString(number)
 	} else if (val.ctype == CBOR_TYPE_STRING) {
 		buf.WriteByte('"')
 		b := val.StringBytes()